@@ -0,0 +1,24 @@
+package main
+
+// The backends confd is built with. Importing a backend package registers
+// it with the backends package.
+import (
+	_ "github.com/kelseyhightower/confd/backends/composite"
+	_ "github.com/kelseyhightower/confd/backends/consul"
+	_ "github.com/kelseyhightower/confd/backends/dynamodb"
+	_ "github.com/kelseyhightower/confd/backends/env"
+	_ "github.com/kelseyhightower/confd/backends/etcd"
+	_ "github.com/kelseyhightower/confd/backends/etcdv3"
+	_ "github.com/kelseyhightower/confd/backends/file"
+	_ "github.com/kelseyhightower/confd/backends/git"
+	_ "github.com/kelseyhightower/confd/backends/http"
+	_ "github.com/kelseyhightower/confd/backends/kubernetes"
+	_ "github.com/kelseyhightower/confd/backends/metad"
+	_ "github.com/kelseyhightower/confd/backends/plugin"
+	_ "github.com/kelseyhightower/confd/backends/rancher"
+	_ "github.com/kelseyhightower/confd/backends/redis"
+	_ "github.com/kelseyhightower/confd/backends/sql"
+	_ "github.com/kelseyhightower/confd/backends/stackengine"
+	_ "github.com/kelseyhightower/confd/backends/vault"
+	_ "github.com/kelseyhightower/confd/backends/zookeeper"
+)
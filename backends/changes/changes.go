@@ -0,0 +1,127 @@
+// Package changes records the changes of keys under an increasing index,
+// so that a watch given the index of the values it rendered returns the
+// changes made since, even those made while it was not waiting. Backends
+// that cannot be notified of changes Poll their values instead, and those
+// comparing the store against a version remember it in States.
+package changes
+
+import (
+	"sync"
+
+	"github.com/kelseyhightower/confd/backends/flatten"
+)
+
+// maxRecent is the number of changes remembered for watches that are not
+// waiting when the change happens.
+const maxRecent = 1024
+
+// change is a change of key. An empty key stands for any key, it is used
+// when changes may have been missed.
+type change struct {
+	index uint64
+	key   string
+}
+
+// matches reports whether ch is a change of one of keys.
+func (ch change) matches(keys []string) bool {
+	if ch.key == "" {
+		return true
+	}
+	for _, key := range keys {
+		if flatten.IsChild(ch.key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// listener is a pending Wait.
+type listener struct {
+	keys  []string
+	fired chan uint64
+}
+
+// Log is a log of the recent changes. The zero value is not usable, use
+// NewLog.
+type Log struct {
+	mu        sync.Mutex
+	index     uint64
+	recent    []change
+	listeners map[*listener]bool
+}
+
+// NewLog returns an empty log at index 1.
+func NewLog() *Log {
+	return &Log{
+		index:     1,
+		listeners: make(map[*listener]bool),
+	}
+}
+
+// Notify records a change of key and wakes up the watches of that key. An
+// empty key wakes up every watch.
+func (l *Log) Notify(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.index++
+	ch := change{l.index, key}
+	l.recent = append(l.recent, ch)
+	if len(l.recent) > maxRecent {
+		l.recent = l.recent[len(l.recent)-maxRecent:]
+	}
+	for ln := range l.listeners {
+		if ch.matches(ln.keys) {
+			select {
+			case ln.fired <- ch.index:
+			default:
+			}
+		}
+	}
+}
+
+// listen registers a watch of keys for changes after waitIndex. The
+// returned listener fires right away if such a change already happened.
+func (l *Log) listen(keys []string, waitIndex uint64) *listener {
+	ln := &listener{keys: keys, fired: make(chan uint64, 1)}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.recent) > 0 && l.recent[0].index > waitIndex+1 {
+		// Older changes were forgotten, assume one of them matched.
+		ln.fired <- l.index
+		return ln
+	}
+	for i := len(l.recent) - 1; i >= 0 && l.recent[i].index > waitIndex; i-- {
+		if l.recent[i].matches(keys) {
+			ln.fired <- l.recent[i].index
+			return ln
+		}
+	}
+	l.listeners[ln] = true
+	return ln
+}
+
+func (l *Log) unlisten(ln *listener) {
+	l.mu.Lock()
+	delete(l.listeners, ln)
+	l.mu.Unlock()
+}
+
+// Wait blocks until a key below keys changes after waitIndex, and returns
+// the index of the change. It returns waitIndex once stopChan fires.
+func (l *Log) Wait(keys []string, waitIndex uint64, stopChan chan bool) uint64 {
+	ln := l.listen(keys, waitIndex)
+	defer l.unlisten(ln)
+	select {
+	case <-stopChan:
+		return waitIndex
+	case index := <-ln.fired:
+		return index
+	}
+}
+
+// Current returns the index of the last change.
+func (l *Log) Current() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.index
+}
@@ -0,0 +1,108 @@
+package changes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitReturnsChangesSinceWaitIndex(t *testing.T) {
+	l := NewLog()
+	first := l.Current()
+	l.Notify("/app/db/host")
+	l.Notify("/other/key")
+
+	// A change made while nobody waited is returned to every watch of the
+	// key, whichever returned first.
+	for i := 0; i < 2; i++ {
+		if got := l.Wait([]string{"/app/db"}, first, nil); got != first+1 {
+			t.Fatalf("Wait() = %d, want %d", got, first+1)
+		}
+	}
+
+	stop := make(chan bool)
+	done := make(chan uint64)
+	go func() { done <- l.Wait([]string{"/app/db"}, first+2, stop) }()
+	l.Notify("/app/dbx")
+	select {
+	case got := <-done:
+		t.Fatalf("Wait() = %d for a change of another key", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.Notify("/app/db/port")
+	select {
+	case got := <-done:
+		if got != first+4 {
+			t.Errorf("Wait() = %d, want %d", got, first+4)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() did not return after a change of the key")
+	}
+}
+
+func TestWaitAfterForgottenChanges(t *testing.T) {
+	l := NewLog()
+	first := l.Current()
+	for i := 0; i < maxRecent+1; i++ {
+		l.Notify("/other")
+	}
+	if got := l.Wait([]string{"/app"}, first, nil); got != l.Current() {
+		t.Errorf("Wait() = %d, want the current index %d", got, l.Current())
+	}
+}
+
+func TestWaitStops(t *testing.T) {
+	l := NewLog()
+	stop := make(chan bool, 1)
+	stop <- true
+	if got := l.Wait([]string{"/app"}, 5, stop); got != 5 {
+		t.Errorf("Wait() = %d, want 5", got)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	vars := map[string]string{"/app/db": "a"}
+	get := func() (map[string]string, error) { return vars, nil }
+	index, err := Poll(get, time.Millisecond, 0, nil)
+	if err != nil || index != Checksum(vars) {
+		t.Fatalf("Poll() = %d, %v, want %d", index, err, Checksum(vars))
+	}
+
+	vars = map[string]string{"/app/db": "b"}
+	got, err := Poll(get, time.Millisecond, index, nil)
+	if err != nil || got == index || got != Checksum(vars) {
+		t.Errorf("Poll() = %d, %v after a change, want %d", got, err, Checksum(vars))
+	}
+}
+
+func TestStatesPut(t *testing.T) {
+	s := NewStates()
+	if got := s.Put(0, "a"); got != 1 {
+		t.Errorf("Put(0) = %d, want 1", got)
+	}
+	if got := s.Put(1, "a"); got != 1 {
+		t.Errorf("Put() of the same state = %d, want 1", got)
+	}
+	// Another state at the same index takes the next free one.
+	if got := s.Put(1, "b"); got != 2 {
+		t.Errorf("Put() of another state = %d, want 2", got)
+	}
+	if state, ok := s.Get(2); !ok || state != "b" {
+		t.Errorf("Get(2) = %v, %v, want b, true", state, ok)
+	}
+}
+
+func TestStatesForgetLeastRecentlyUsed(t *testing.T) {
+	s := NewStates()
+	s.Put(1, "watched")
+	for i := uint64(2); i <= maxStates+1; i++ {
+		// The watch holding index 1 keeps using it.
+		s.Get(1)
+		s.Put(i, i)
+	}
+	if _, ok := s.Get(1); !ok {
+		t.Error("Get() forgot the state still in use")
+	}
+	if _, ok := s.Get(2); ok {
+		t.Error("Get() still knows the least recently used state")
+	}
+}
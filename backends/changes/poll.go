@@ -0,0 +1,49 @@
+package changes
+
+import (
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// Poll calls get every interval until the values it returns differ from
+// the ones of waitIndex, and returns their checksum as index. A zero
+// waitIndex returns the checksum of the current values right away.
+func Poll(get func() (map[string]string, error), interval time.Duration, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	for {
+		if waitIndex != 0 {
+			select {
+			case <-stopChan:
+				return waitIndex, nil
+			case <-time.After(interval):
+			}
+		}
+		vars, err := get()
+		if err != nil {
+			return waitIndex, err
+		}
+		if index := Checksum(vars); index != waitIndex {
+			return index, nil
+		}
+	}
+}
+
+// Checksum returns a non-zero hash of vars.
+func Checksum(vars map[string]string) uint64 {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := fnv.New64a()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(vars[k]))
+		h.Write([]byte{0})
+	}
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
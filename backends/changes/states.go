@@ -0,0 +1,77 @@
+package changes
+
+import (
+	"container/list"
+	"sync"
+)
+
+// maxStates is the number of states remembered by States. The least
+// recently used ones are forgotten first, so only watches left behind by
+// many newer ones lose theirs.
+const maxStates = 1024
+
+// entry is the state of an index.
+type entry struct {
+	index uint64
+	state interface{}
+}
+
+// States remembers the state of the store, an ETag or a commit for
+// instance, each index returned by a watch stands for. A watch given one of
+// these indexes compares the store against its state, so that every caller
+// sees the changes, whichever returned first. The zero value is not usable,
+// use NewStates.
+type States struct {
+	mu      sync.Mutex
+	entries map[uint64]*list.Element
+	used    *list.List
+}
+
+// NewStates returns an empty set of states.
+func NewStates() *States {
+	return &States{
+		entries: make(map[uint64]*list.Element),
+		used:    list.New(),
+	}
+}
+
+// Get returns the state of index, and whether it is known.
+func (s *States) Get(index uint64) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[index]
+	if !ok {
+		return nil, false
+	}
+	s.used.MoveToFront(e)
+	return e.Value.(*entry).state, true
+}
+
+// Put remembers state under index and returns the index. If index already
+// stands for another state, or is zero, the next free index is used
+// instead. States are compared with ==.
+func (s *States) Put(index uint64, state interface{}) uint64 {
+	if index == 0 {
+		index = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		e, ok := s.entries[index]
+		if !ok {
+			break
+		}
+		if e.Value.(*entry).state == state {
+			s.used.MoveToFront(e)
+			return index
+		}
+		index++
+	}
+	s.entries[index] = s.used.PushFront(&entry{index, state})
+	if s.used.Len() > maxStates {
+		oldest := s.used.Back()
+		s.used.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry).index)
+	}
+	return index
+}
@@ -0,0 +1,165 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/consul"
+)
+
+func init() {
+	backends.Register("composite", func(config backends.Config) (backends.StoreClient, error) {
+		c, err := NewCompositeClient(config.Layers)
+		if err != nil {
+			return nil, err
+		}
+		return c.withCatalog(), nil
+	})
+}
+
+// Client stacks several store clients. Values of later layers
+// override values of earlier ones.
+type Client struct {
+	names  []string
+	layers []backends.StoreClient
+
+	mu  sync.Mutex
+	seq uint64
+	// watched holds the wait index of every layer, by the index returned
+	// to the caller of WatchPrefix.
+	watched map[uint64][]uint64
+}
+
+// NewCompositeClient creates a client for every layer, in order.
+func NewCompositeClient(layers []backends.Config) (*Client, error) {
+	if len(layers) == 0 {
+		return nil, errors.New("no layers configured for the composite backend")
+	}
+	c := &Client{watched: make(map[uint64][]uint64)}
+	for i, layer := range layers {
+		if layer.Backend == "composite" {
+			return nil, fmt.Errorf("composite layer %d: layers cannot be composite", i)
+		}
+		client, err := backends.New(layer)
+		if err != nil {
+			return nil, fmt.Errorf("composite layer %d (%s): %s", i, layer.Backend, err)
+		}
+		c.names = append(c.names, layer.Backend)
+		c.layers = append(c.layers, client)
+	}
+	return c, nil
+}
+
+// GetValues merges the values of all layers, later layers winning.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for i, layer := range c.layers {
+		values, err := layer.GetValues(keys)
+		if err != nil {
+			return vars, fmt.Errorf("composite layer %d (%s): %s", i, c.names[i], err)
+		}
+		for k, v := range values {
+			vars[k] = v
+		}
+	}
+	return vars, nil
+}
+
+type layerResponse struct {
+	layer     int
+	waitIndex uint64
+	err       error
+}
+
+// watchLayer runs a single watch of layer i and sends its outcome to
+// respChan unless stopChan is closed first.
+func (c *Client) watchLayer(i int, prefix string, keys []string, waitIndex uint64, stopChan chan bool, respChan chan layerResponse) {
+	index, err := c.layers[i].WatchPrefix(prefix, keys, waitIndex, stopChan)
+	select {
+	case respChan <- layerResponse{i, index, err}:
+	case <-stopChan:
+	}
+}
+
+// WatchPrefix watches every layer and returns as soon as one of them
+// reports a change.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	c.mu.Lock()
+	indexes, ok := c.watched[waitIndex]
+	if waitIndex == 0 || !ok {
+		indexes = make([]uint64, len(c.layers))
+	}
+	indexes = append([]uint64(nil), indexes...)
+	c.mu.Unlock()
+
+	// return something > 0 to trigger a key retrieval from the store
+	if waitIndex == 0 {
+		return c.next(waitIndex, indexes), nil
+	}
+
+	// Layers share a stop channel which is closed, rather than written to,
+	// so that every one of them sees it.
+	stop := make(chan bool)
+	defer close(stop)
+
+	respChan := make(chan layerResponse)
+	for i := range c.layers {
+		go c.watchLayer(i, prefix, keys, indexes[i], stop, respChan)
+	}
+
+	for {
+		select {
+		case <-stopChan:
+			return waitIndex, nil
+		case r := <-respChan:
+			if r.err != nil {
+				return waitIndex, fmt.Errorf("composite layer %d (%s): %s", r.layer, c.names[r.layer], r.err)
+			}
+			primed := indexes[r.layer] != 0
+			indexes[r.layer] = r.waitIndex
+			if primed {
+				return c.next(waitIndex, indexes), nil
+			}
+			// The layer only returned the index to start watching from.
+			go c.watchLayer(r.layer, prefix, keys, r.waitIndex, stop, respChan)
+		}
+	}
+}
+
+// next records the layer indexes under a new index and returns it.
+func (c *Client) next(waitIndex uint64, indexes []uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.watched, waitIndex)
+	c.seq++
+	c.watched[c.seq] = indexes
+	return c.seq
+}
+
+// catalogLayer is implemented by layers that provide a service catalog.
+type catalogLayer interface {
+	Service(ctx context.Context, name, tag string, waitIndex uint64) ([]consul.ServiceInstance, uint64, error)
+	Services(ctx context.Context, waitIndex uint64) ([]consul.ServiceSummary, uint64, error)
+	Nodes(ctx context.Context, waitIndex uint64) ([]consul.Node, uint64, error)
+}
+
+// catalogClient is a composite client with a layer providing a service
+// catalog. Its catalog is the one of the last such layer.
+type catalogClient struct {
+	*Client
+	catalogLayer
+}
+
+// withCatalog returns c, with the service catalog of its last layer
+// providing one, if any.
+func (c *Client) withCatalog() backends.StoreClient {
+	for i := len(c.layers) - 1; i >= 0; i-- {
+		if layer, ok := c.layers[i].(catalogLayer); ok {
+			return &catalogClient{c, layer}
+		}
+	}
+	return c
+}
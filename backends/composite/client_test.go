@@ -0,0 +1,133 @@
+package composite
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/confd/backends/consul"
+)
+
+// fakeStore is a StoreClient whose values and changes are driven by the
+// test.
+type fakeStore struct {
+	values  map[string]string
+	index   uint64
+	changes chan uint64
+}
+
+func (s *fakeStore) GetValues(keys []string) (map[string]string, error) {
+	return s.values, nil
+}
+
+func (s *fakeStore) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if waitIndex == 0 {
+		return s.index, nil
+	}
+	select {
+	case i := <-s.changes:
+		return i, nil
+	case <-stopChan:
+		return waitIndex, nil
+	}
+}
+
+func newFakeComposite(layers ...*fakeStore) *Client {
+	c := &Client{watched: make(map[uint64][]uint64)}
+	for _, layer := range layers {
+		c.names = append(c.names, "fake")
+		c.layers = append(c.layers, layer)
+	}
+	return c
+}
+
+func TestCompositeGetValues(t *testing.T) {
+	defaults := &fakeStore{values: map[string]string{"/app/port": "80", "/app/host": "localhost"}}
+	overrides := &fakeStore{values: map[string]string{"/app/port": "8080"}}
+	c := newFakeComposite(defaults, overrides)
+
+	vars, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"/app/port": "8080", "/app/host": "localhost"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+}
+
+func TestCompositeWatchPrefix(t *testing.T) {
+	first := &fakeStore{index: 10, changes: make(chan uint64, 1)}
+	second := &fakeStore{index: 20, changes: make(chan uint64, 1)}
+	c := newFakeComposite(first, second)
+	stopChan := make(chan bool)
+
+	index, err := c.WatchPrefix("/", []string{"/app"}, 0, stopChan)
+	if err != nil || index == 0 {
+		t.Fatalf("WatchPrefix(0) = %d, %v, want an index > 0", index, err)
+	}
+
+	for _, layer := range []*fakeStore{second, first} {
+		done := make(chan uint64)
+		go func(waitIndex uint64) {
+			i, err := c.WatchPrefix("/", []string{"/app"}, waitIndex, stopChan)
+			if err != nil {
+				t.Error(err)
+			}
+			done <- i
+		}(index)
+
+		select {
+		case i := <-done:
+			t.Fatalf("WatchPrefix returned %d before any layer changed", i)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		layer.changes <- layer.index + 1
+		select {
+		case i := <-done:
+			if i <= index {
+				t.Fatalf("WatchPrefix() = %d, want more than %d", i, index)
+			}
+			index = i
+		case <-time.After(time.Second):
+			t.Fatal("WatchPrefix did not return after a layer changed")
+		}
+	}
+}
+
+// fakeCatalogStore is a fakeStore providing a service catalog.
+type fakeCatalogStore struct {
+	*fakeStore
+}
+
+func (s fakeCatalogStore) Service(ctx context.Context, name, tag string, waitIndex uint64) ([]consul.ServiceInstance, uint64, error) {
+	return []consul.ServiceInstance{{Name: name}}, 1, nil
+}
+
+func (s fakeCatalogStore) Services(ctx context.Context, waitIndex uint64) ([]consul.ServiceSummary, uint64, error) {
+	return nil, 1, nil
+}
+
+func (s fakeCatalogStore) Nodes(ctx context.Context, waitIndex uint64) ([]consul.Node, uint64, error) {
+	return nil, 1, nil
+}
+
+func TestCompositeCatalog(t *testing.T) {
+	c := newFakeComposite(&fakeStore{})
+	if _, ok := c.withCatalog().(catalogLayer); ok {
+		t.Error("composite without a catalog layer provides a service catalog")
+	}
+
+	c.names = append(c.names, "fake")
+	c.layers = append(c.layers, fakeCatalogStore{&fakeStore{}})
+	catalog, ok := c.withCatalog().(catalogLayer)
+	if !ok {
+		t.Fatal("composite with a catalog layer does not provide a service catalog")
+	}
+	instances, _, err := catalog.Service(context.Background(), "web", "", 0)
+	if err != nil || len(instances) != 1 || instances[0].Name != "web" {
+		t.Errorf("Service() = %v, %v, want the instances of the catalog layer", instances, err)
+	}
+}
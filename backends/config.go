@@ -1,5 +1,7 @@
 package backends
 
+// Config holds the settings shared by the backends. Settings specific to a
+// backend are decoded from Options with DecodeOptions.
 type Config struct {
 	AuthToken    string
 	AuthType     string
@@ -9,6 +11,8 @@ type Config struct {
 	ClientCert   string
 	ClientKey    string
 	BackendNodes []string
+	Layers       []Config
+	Options      map[string]interface{}
 	Password     string
 	Scheme       string
 	Table        string
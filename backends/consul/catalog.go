@@ -0,0 +1,209 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// ServiceInstance is a healthy instance of a service.
+type ServiceInstance struct {
+	ID      string
+	Name    string
+	Node    string
+	Address string
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+}
+
+// ServiceSummary is a service registered in the catalog and the union of
+// the tags of its instances.
+type ServiceSummary struct {
+	Name string
+	Tags []string
+}
+
+// Node is a node registered in the catalog.
+type Node struct {
+	Name    string
+	Address string
+	Meta    map[string]string
+}
+
+// healthEntry is an entry of /v1/health/service. The vendored API client
+// predates service and node metadata, so the response is decoded here.
+type healthEntry struct {
+	Node struct {
+		Node    string
+		Address string
+		Meta    map[string]string
+	}
+	Service struct {
+		ID      string
+		Service string
+		Tags    []string
+		Address string
+		Port    int
+		Meta    map[string]string
+	}
+	Checks []struct {
+		Status string
+	}
+}
+
+// healthy reports whether all the checks of e pass.
+func (e healthEntry) healthy() bool {
+	for _, check := range e.Checks {
+		if check.Status != "passing" {
+			return false
+		}
+	}
+	return true
+}
+
+// hasTag reports whether the service of e is tagged with tag.
+func (e healthEntry) hasTag(tag string) bool {
+	for _, t := range e.Service.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type catalogNode struct {
+	Node    string
+	Address string
+	Meta    map[string]string
+}
+
+// query runs a blocking query of endpoint, an escaped path, against the
+// nodes in turn until ctx is done. A waitIndex of 0 returns right away.
+func (c *ConsulClient) query(ctx context.Context, endpoint string, out interface{}, waitIndex uint64) (uint64, error) {
+	opts, err := c.queryOptions(waitIndex)
+	if err != nil {
+		return waitIndex, err
+	}
+	params := url.Values{}
+	if c.datacenter != "" {
+		params.Set("dc", c.datacenter)
+	}
+	if opts.AllowStale {
+		params.Set("stale", "")
+	}
+	if opts.RequireConsistent {
+		params.Set("consistent", "")
+	}
+	if opts.WaitIndex != 0 {
+		params.Set("index", strconv.FormatUint(opts.WaitIndex, 10))
+	}
+	if opts.Token != "" {
+		params.Set("token", opts.Token)
+	}
+
+	index := waitIndex
+	err = c.do(ctx, func(node int) error {
+		req, err := http.NewRequest("GET", c.urls[node]+endpoint+"?"+params.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.httpClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Unexpected response code: %d", resp.StatusCode)
+		}
+		if index, err = strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64); err != nil {
+			return fmt.Errorf("Failed to parse X-Consul-Index: %v", err)
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	})
+	if err != nil {
+		return waitIndex, err
+	}
+	return index, nil
+}
+
+// Service returns the instances of service name whose health checks all
+// pass, optionally only those tagged with tag. If waitIndex is not 0, it
+// blocks until the result changes from the one at waitIndex. It returns
+// the index of the result. Queries blocking on waitIndex stop once ctx is
+// done.
+func (c *ConsulClient) Service(ctx context.Context, name, tag string, waitIndex uint64) ([]ServiceInstance, uint64, error) {
+	// The entries are filtered here rather than with the passing and tag
+	// parameters, which older agents do not support.
+	var entries []healthEntry
+	index, err := c.query(ctx, "/v1/health/service/"+url.PathEscape(name), &entries, waitIndex)
+	if err != nil {
+		return nil, index, err
+	}
+
+	instances := make([]ServiceInstance, 0, len(entries))
+	for _, e := range entries {
+		if !e.healthy() || (tag != "" && !e.hasTag(tag)) {
+			continue
+		}
+		address := e.Service.Address
+		if address == "" {
+			address = e.Node.Address
+		}
+		instances = append(instances, ServiceInstance{
+			ID:      e.Service.ID,
+			Name:    e.Service.Service,
+			Node:    e.Node.Node,
+			Address: address,
+			Port:    e.Service.Port,
+			Tags:    e.Service.Tags,
+			Meta:    e.Service.Meta,
+		})
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Node != instances[j].Node {
+			return instances[i].Node < instances[j].Node
+		}
+		return instances[i].ID < instances[j].ID
+	})
+	return instances, index, nil
+}
+
+// Services returns the services of the catalog sorted by name. ctx,
+// waitIndex and the returned index work as for Service.
+func (c *ConsulClient) Services(ctx context.Context, waitIndex uint64) ([]ServiceSummary, uint64, error) {
+	var services map[string][]string
+	index, err := c.query(ctx, "/v1/catalog/services", &services, waitIndex)
+	if err != nil {
+		return nil, index, err
+	}
+
+	summaries := make([]ServiceSummary, 0, len(services))
+	for name, tags := range services {
+		sort.Strings(tags)
+		summaries = append(summaries, ServiceSummary{Name: name, Tags: tags})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries, index, nil
+}
+
+// Nodes returns the nodes of the catalog sorted by name. ctx, waitIndex and
+// the returned index work as for Service.
+func (c *ConsulClient) Nodes(ctx context.Context, waitIndex uint64) ([]Node, uint64, error) {
+	var entries []catalogNode
+	index, err := c.query(ctx, "/v1/catalog/nodes", &entries, waitIndex)
+	if err != nil {
+		return nil, index, err
+	}
+
+	nodes := make([]Node, 0, len(entries))
+	for _, e := range entries {
+		nodes = append(nodes, Node{Name: e.Node, Address: e.Address, Meta: e.Meta})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes, index, nil
+}
@@ -0,0 +1,113 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCatalog(t *testing.T) {
+	var query string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Header().Set("X-Consul-Index", "7")
+		switch r.URL.Path {
+		case "/v1/health/service/web":
+			fmt.Fprint(w, `[
+				{"Node": {"Node": "b", "Address": "10.0.0.2"},
+				 "Service": {"ID": "web-2", "Service": "web", "Tags": ["v1", "v2"], "Port": 8080, "Meta": {"weight": "3"}},
+				 "Checks": [{"Status": "passing"}]},
+				{"Node": {"Node": "a", "Address": "10.0.0.1"},
+				 "Service": {"ID": "web-1", "Service": "web", "Tags": ["v1"], "Address": "192.168.0.1", "Port": 80},
+				 "Checks": [{"Status": "passing"}, {"Status": "passing"}]},
+				{"Node": {"Node": "c", "Address": "10.0.0.3"},
+				 "Service": {"ID": "web-3", "Service": "web", "Tags": ["v1"], "Port": 80},
+				 "Checks": [{"Status": "passing"}, {"Status": "critical"}]},
+				{"Node": {"Node": "d", "Address": "10.0.0.4"},
+				 "Service": {"ID": "web-4", "Service": "web", "Tags": ["v3"], "Port": 80}}
+			]`)
+		case "/v1/catalog/services":
+			fmt.Fprint(w, `{"web": ["v2", "v1"], "consul": []}`)
+		case "/v1/catalog/nodes":
+			fmt.Fprint(w, `[{"Node": "b", "Address": "10.0.0.2", "Meta": {"rack": "r2"}}, {"Node": "a", "Address": "10.0.0.1"}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	c, err := New([]string{s.URL}, "http", "", "", "", "", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, index, err := c.Service(context.Background(), "web", "v1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 7 {
+		t.Errorf("Service() index = %d, want 7", index)
+	}
+	if query != "index=3" {
+		t.Errorf("Service() query = %q, want a blocking query from index 3", query)
+	}
+	want := []ServiceInstance{
+		{ID: "web-1", Name: "web", Node: "a", Address: "192.168.0.1", Port: 80, Tags: []string{"v1"}},
+		{ID: "web-2", Name: "web", Node: "b", Address: "10.0.0.2", Port: 8080, Tags: []string{"v1", "v2"}, Meta: map[string]string{"weight": "3"}},
+	}
+	if !reflect.DeepEqual(instances, want) {
+		t.Errorf("Service() = %+v, want %+v", instances, want)
+	}
+
+	services, _, err := c.Services(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantServices := []ServiceSummary{{Name: "consul", Tags: []string{}}, {Name: "web", Tags: []string{"v1", "v2"}}}
+	if !reflect.DeepEqual(services, wantServices) {
+		t.Errorf("Services() = %+v, want %+v", services, wantServices)
+	}
+
+	nodes, _, err := c.Nodes(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNodes := []Node{{Name: "a", Address: "10.0.0.1"}, {Name: "b", Address: "10.0.0.2", Meta: map[string]string{"rack": "r2"}}}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("Nodes() = %+v, want %+v", nodes, wantNodes)
+	}
+}
+
+func TestServiceEscapesNameAndCancels(t *testing.T) {
+	paths := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.EscapedPath()
+		// Hold the blocking query until the client gives up.
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+
+	c, err := New([]string{s.URL}, "http", "", "", "", "", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.Service(ctx, "web/v1?x", "", 3)
+		done <- err
+	}()
+	if path := <-paths; path != "/v1/health/service/web%2Fv1%3Fx" {
+		t.Errorf("Service() path = %s, want the escaped name", path)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Service() did not return once its context was cancelled")
+	}
+}
@@ -1,29 +1,88 @@
 package consul
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/log"
 )
 
+// Options are the consul specific backend options.
+type Options struct {
+	// Datacenter to query instead of the agent's own.
+	Datacenter string `mapstructure:"datacenter"`
+	// Consistency is the read mode: "default", "stale" or "consistent".
+	Consistency string `mapstructure:"consistency"`
+	// Namespace to read from, Consul Enterprise only.
+	Namespace string `mapstructure:"namespace"`
+	// TokenFile holds the ACL token. It is read again before every
+	// request, so that the token can be rotated.
+	TokenFile string `mapstructure:"token_file"`
+}
+
 // Client provides a wrapper around the consulkv client
 type ConsulClient struct {
-	client *api.KV
+	clients []*api.Client
+	nodes   []string
+	// urls and httpClient serve the catalog queries, which the vendored
+	// API client cannot cancel.
+	urls        []string
+	httpClient  *http.Client
+	datacenter  string
+	consistency string
+	token       string
+	tokenFile   string
+
+	mu        sync.Mutex
+	current   int
+	fileToken string
 }
 
-// NewConsulClient returns a new client to Consul for the given address
-func New(nodes []string, scheme, cert, key, caCert string) (*ConsulClient, error) {
-	conf := api.DefaultConfig()
+// namespaceTransport sets the namespace header on every request.
+type namespaceTransport struct {
+	namespace string
+	transport http.RoundTripper
+}
 
-	conf.Scheme = scheme
+func (t *namespaceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("X-Consul-Namespace", t.namespace)
+	return t.transport.RoundTrip(r)
+}
 
-	if len(nodes) > 0 {
-		conf.Address = nodes[0]
+func init() {
+	backends.Register("consul", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return New(config.BackendNodes, config.Scheme,
+			config.ClientCert, config.ClientKey,
+			config.ClientCaKeys, config.AuthToken, opts)
+	})
+}
+
+// NewConsulClient returns a new client to Consul for the given addresses.
+// Requests go to the first address that answers.
+func New(nodes []string, scheme, cert, key, caCert, token string, opts Options) (*ConsulClient, error) {
+	switch opts.Consistency {
+	case "", "default", "stale", "consistent":
+	default:
+		return nil, fmt.Errorf("invalid consul consistency mode %q, expected default, stale or consistent", opts.Consistency)
 	}
 
 	tlsConfig := &tls.Config{}
@@ -44,15 +103,108 @@ func New(nodes []string, scheme, cert, key, caCert string) (*ConsulClient, error
 		caCertPool.AppendCertsFromPEM(ca)
 		tlsConfig.RootCAs = caCertPool
 	}
-	conf.HttpClient.Transport = &http.Transport{
+	var transport http.RoundTripper = &http.Transport{
 		TLSClientConfig: tlsConfig,
 	}
+	if opts.Namespace != "" {
+		transport = &namespaceTransport{opts.Namespace, transport}
+	}
+
+	if len(nodes) == 0 {
+		nodes = []string{api.DefaultConfig().Address}
+	}
+	c := &ConsulClient{
+		nodes:       nodes,
+		httpClient:  &http.Client{Transport: transport},
+		datacenter:  opts.Datacenter,
+		consistency: opts.Consistency,
+		token:       token,
+		tokenFile:   opts.TokenFile,
+	}
+	if c.tokenFile != "" {
+		if _, err := c.readToken(); err != nil {
+			return nil, err
+		}
+	}
+	for _, node := range nodes {
+		conf := api.DefaultConfig()
+		conf.Scheme = scheme
+		conf.Address = node
+		if i := strings.Index(node, "://"); i >= 0 {
+			// Nodes from SRV records carry their scheme.
+			conf.Scheme, conf.Address = node[:i], node[i+3:]
+		}
+		conf.Datacenter = opts.Datacenter
+		conf.HttpClient.Transport = transport
+		client, err := api.NewClient(conf)
+		if err != nil {
+			return nil, err
+		}
+		c.clients = append(c.clients, client)
+		c.urls = append(c.urls, conf.Scheme+"://"+conf.Address)
+	}
+	return c, nil
+}
 
-	client, err := api.NewClient(conf)
+// readToken reads the token file, falling back to the last token read if
+// it cannot be read anymore.
+func (c *ConsulClient) readToken() (string, error) {
+	b, err := ioutil.ReadFile(c.tokenFile)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if err != nil {
-		return nil, err
+		if c.fileToken != "" {
+			log.Warning("Cannot read consul token file %s, using the last token read: %s", c.tokenFile, err)
+			return c.fileToken, nil
+		}
+		return "", err
+	}
+	c.fileToken = strings.TrimSpace(string(b))
+	return c.fileToken, nil
+}
+
+// queryOptions returns the options shared by all queries.
+func (c *ConsulClient) queryOptions(waitIndex uint64) (*api.QueryOptions, error) {
+	opts := &api.QueryOptions{
+		AllowStale:        c.consistency == "stale",
+		RequireConsistent: c.consistency == "consistent",
+		WaitIndex:         waitIndex,
+		Token:             c.token,
+	}
+	if opts.Token == "" && c.tokenFile != "" {
+		token, err := c.readToken()
+		if err != nil {
+			return nil, err
+		}
+		opts.Token = token
+	}
+	return opts, nil
+}
+
+// do runs f against the nodes in turn, starting with the last one that
+// answered, until it succeeds or ctx is done.
+func (c *ConsulClient) do(ctx context.Context, f func(node int) error) error {
+	c.mu.Lock()
+	start := c.current
+	c.mu.Unlock()
+
+	var err error
+	for i := 0; i < len(c.clients); i++ {
+		idx := (start + i) % len(c.clients)
+		if err = f(idx); err == nil {
+			c.mu.Lock()
+			c.current = idx
+			c.mu.Unlock()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if len(c.clients) > 1 {
+			log.Warning("consul node %s failed: %s", c.nodes[idx], err)
+		}
 	}
-	return &ConsulClient{client.KV()}, nil
+	return err
 }
 
 // GetValues queries Consul for keys
@@ -60,7 +212,16 @@ func (c *ConsulClient) GetValues(keys []string) (map[string]string, error) {
 	vars := make(map[string]string)
 	for _, key := range keys {
 		key := strings.TrimPrefix(key, "/")
-		pairs, _, err := c.client.List(key, nil)
+		opts, err := c.queryOptions(0)
+		if err != nil {
+			return vars, err
+		}
+		var pairs api.KVPairs
+		err = c.do(context.Background(), func(node int) error {
+			var err error
+			pairs, _, err = c.clients[node].KV().List(key, opts)
+			return err
+		})
 		if err != nil {
 			return vars, err
 		}
@@ -77,12 +238,19 @@ type watchResponse struct {
 }
 
 func (c *ConsulClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	respChan := make(chan watchResponse)
+	respChan := make(chan watchResponse, 1)
 	go func() {
-		opts := api.QueryOptions{
-			WaitIndex: waitIndex,
+		opts, err := c.queryOptions(waitIndex)
+		if err != nil {
+			respChan <- watchResponse{waitIndex, err}
+			return
 		}
-		_, meta, err := c.client.List(prefix, &opts)
+		var meta *api.QueryMeta
+		err = c.do(context.Background(), func(node int) error {
+			var err error
+			_, meta, err = c.clients[node].KV().List(strings.TrimPrefix(prefix, "/"), opts)
+			return err
+		})
 		if err != nil {
 			respChan <- watchResponse{waitIndex, err}
 			return
@@ -0,0 +1,136 @@
+package consul
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeConsul serves a fixed KV store and records the requests it gets.
+type fakeConsul struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newFakeConsul(index uint64) *fakeConsul {
+	f := &fakeConsul{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests = append(f.requests, r)
+		f.mu.Unlock()
+		if !strings.HasPrefix(r.URL.Path, "/v1/kv/app") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Consul-Index", fmt.Sprint(index))
+		fmt.Fprintf(w, `[{"Key":"app/db","Value":%q},{"Key":"app/port","Value":%q}]`,
+			base64.StdEncoding.EncodeToString([]byte("db.local")),
+			base64.StdEncoding.EncodeToString([]byte("5432")))
+	}))
+	return f
+}
+
+func (f *fakeConsul) lastRequest() *http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		return nil
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+func address(s *httptest.Server) string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func TestFailover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	up := newFakeConsul(42)
+	defer up.Close()
+
+	c, err := New([]string{address(down), address(up.Server)}, "http", "", "", "", "", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"/app/db": "db.local", "/app/port": "5432"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+
+	index, err := c.WatchPrefix("/app", []string{"/app"}, 0, make(chan bool))
+	if err != nil || index != 42 {
+		t.Errorf("WatchPrefix() = %d, %v, want 42", index, err)
+	}
+	if c.current != 1 {
+		t.Errorf("current node = %d, want the node that answered", c.current)
+	}
+}
+
+func TestQueryOptions(t *testing.T) {
+	f := newFakeConsul(1)
+	defer f.Close()
+
+	dir, err := ioutil.TempDir("", "confd-consul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{
+		Datacenter:  "dc2",
+		Consistency: "stale",
+		Namespace:   "team-a",
+		TokenFile:   tokenFile,
+	}
+	c, err := New([]string{f.URL}, "https", "", "", "", "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetValues([]string{"/app"}); err != nil {
+		t.Fatal(err)
+	}
+	r := f.lastRequest()
+	q := r.URL.Query()
+	if q.Get("dc") != "dc2" || q.Get("token") != "first" || q["stale"] == nil {
+		t.Errorf("query = %s, want dc, token and stale", r.URL.RawQuery)
+	}
+	if ns := r.Header.Get("X-Consul-Namespace"); ns != "team-a" {
+		t.Errorf("namespace header = %q, want team-a", ns)
+	}
+
+	// The token file is read again, and an explicit token wins over it.
+	if err := ioutil.WriteFile(tokenFile, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c.GetValues([]string{"/app"})
+	if token := f.lastRequest().URL.Query().Get("token"); token != "second" {
+		t.Errorf("token = %q after rotation, want second", token)
+	}
+	c.token = "explicit"
+	c.GetValues([]string{"/app"})
+	if token := f.lastRequest().URL.Query().Get("token"); token != "explicit" {
+		t.Errorf("token = %q, want the -auth-token value", token)
+	}
+
+	if _, err := New([]string{f.URL}, "http", "", "", "", "", Options{Consistency: "eventual"}); err == nil {
+		t.Error("New() accepted an invalid consistency mode")
+	}
+}
@@ -1,34 +1,104 @@
 package dynamodb
 
 import (
+	"errors"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/flatten"
 	"github.com/kelseyhightower/confd/log"
 )
 
+// Options are the DynamoDB specific settings, set as backend options.
+type Options struct {
+	// Region, Endpoint and Profile override the region, the endpoint and
+	// the shared credentials profile of the AWS environment.
+	Region   string `mapstructure:"region"`
+	Endpoint string `mapstructure:"endpoint"`
+	Profile  string `mapstructure:"profile"`
+	// KeyAttribute and ValueAttribute name the attributes holding the keys
+	// and the values.
+	KeyAttribute   string `mapstructure:"key_attribute"`
+	ValueAttribute string `mapstructure:"value_attribute"`
+	// PartitionKey and PartitionValue switch from scanning the table to
+	// querying the items whose partition key PartitionKey is
+	// PartitionValue, using the key attribute as sort key.
+	PartitionKey   string `mapstructure:"partition_key"`
+	PartitionValue string `mapstructure:"partition_value"`
+	// Index is the global secondary index to query instead of the table.
+	Index string `mapstructure:"index"`
+	// StreamsEndpoint overrides the endpoint of the DynamoDB Streams API,
+	// which defaults to Endpoint when it is set.
+	StreamsEndpoint string `mapstructure:"streams_endpoint"`
+	// PollInterval is how often the values are read again to watch a
+	// table without stream.
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+}
+
 // Client is a wrapper around the DynamoDB client
 // and also holds the table to lookup key value pairs from
 type Client struct {
-	client *dynamodb.DynamoDB
-	table  string
+	client  *dynamodb.DynamoDB
+	streams *streams
+	table   string
+	opts    Options
+
+	watchOnce sync.Once
+	watcher   *watcher
+}
+
+func init() {
+	backends.Register("dynamodb", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		table := config.Table
+		log.Info("DynamoDB table set to " + table)
+		return NewDynamoDBClient(table, opts)
+	})
 }
 
 // NewDynamoDBClient returns an *dynamodb.Client with a connection to the region
-// configured via the AWS_REGION environment variable.
+// configured via the AWS_REGION environment variable, unless opts set another.
 // It returns an error if the connection cannot be made or the table does not exist.
-func NewDynamoDBClient(table string) (*Client, error) {
-	var c *aws.Config
+func NewDynamoDBClient(table string, opts Options) (*Client, error) {
+	if opts.KeyAttribute == "" {
+		opts.KeyAttribute = "key"
+	}
+	if opts.ValueAttribute == "" {
+		opts.ValueAttribute = "value"
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	if opts.PartitionKey != "" && opts.PartitionValue == "" {
+		return nil, errors.New("partition_value is required to query on partition_key")
+	}
+	if opts.Index != "" && opts.PartitionKey == "" {
+		return nil, errors.New("partition_key is required to query an index")
+	}
+
+	c := &aws.Config{}
 	if os.Getenv("DYNAMODB_LOCAL") != "" {
 		log.Debug("DYNAMODB_LOCAL is set")
-		endpoint := "http://localhost:8000"
-		c = &aws.Config{
-			Endpoint: &endpoint,
-		}
-	} else {
-		c = nil
+		c.Endpoint = aws.String("http://localhost:8000")
+	}
+	if opts.Endpoint != "" {
+		c.Endpoint = aws.String(opts.Endpoint)
+	}
+	if opts.Region != "" {
+		c.Region = aws.String(opts.Region)
+	}
+	if opts.Profile != "" {
+		c.Credentials = credentials.NewSharedCredentials("", opts.Profile)
 	}
 
 	session := session.New(c)
@@ -46,66 +116,150 @@ func NewDynamoDBClient(table string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Client{d, table}, nil
+
+	s := &aws.Config{}
+	if opts.StreamsEndpoint != "" {
+		s.Endpoint = aws.String(opts.StreamsEndpoint)
+	}
+	return &Client{client: d, streams: newStreams(session, s), table: table, opts: opts}, nil
 }
 
 // GetValues retrieves the values for the given keys from DynamoDB
 func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	vars := make(map[string]string)
 	for _, key := range keys {
-		// Check if we can find the single item
-		m := make(map[string]*dynamodb.AttributeValue)
-		m["key"] = &dynamodb.AttributeValue{S: aws.String(key)}
-		g, err := c.client.GetItem(&dynamodb.GetItemInput{Key: m, TableName: &c.table})
+		var err error
+		if c.opts.PartitionKey != "" {
+			err = c.query(key, vars)
+		} else {
+			err = c.scan(key, vars)
+		}
 		if err != nil {
 			return vars, err
 		}
+	}
+	return vars, nil
+}
 
-		if g.Item != nil {
-			if val, ok := g.Item["value"]; ok {
-				if val.S != nil {
-					vars[key] = *val.S
-				} else {
-					log.Warning("Skipping key '%s'. 'value' is not of type 'string'.", key)
-				}
-				continue
-			}
-		}
-
-		// Check for nested keys
-		q, err := c.client.Scan(
-			&dynamodb.ScanInput{
-				ScanFilter: map[string]*dynamodb.Condition{
-					"key": &dynamodb.Condition{
-						AttributeValueList: []*dynamodb.AttributeValue{
-							&dynamodb.AttributeValue{S: aws.String(key)}},
-						ComparisonOperator: aws.String("BEGINS_WITH")}},
-				AttributesToGet: []*string{aws.String("key"), aws.String("value")},
-				TableName:       aws.String(c.table),
-				Select:          aws.String("SPECIFIC_ATTRIBUTES"),
-			})
+// scan reads the item of key, or else the items whose key begins with key,
+// page by page.
+func (c *Client) scan(key string, vars map[string]string) error {
+	// Check if we can find the single item
+	m := make(map[string]*dynamodb.AttributeValue)
+	m[c.opts.KeyAttribute] = &dynamodb.AttributeValue{S: aws.String(key)}
+	g, err := c.client.GetItem(&dynamodb.GetItemInput{Key: m, TableName: &c.table})
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			return vars, err
+	if g.Item != nil {
+		if _, ok := g.Item[c.opts.ValueAttribute]; ok {
+			c.store(g.Item, vars)
+			return nil
 		}
+	}
 
-		for _, i := range q.Items {
-			item := i
-			if val, ok := item["value"]; ok {
-				if val.S != nil {
-					vars[*item["key"].S] = *val.S
-				} else {
-					log.Warning("Skipping key '%s'. 'value' is not of type 'string'.", *item["key"].S)
-				}
-				continue
+	// Check for nested keys
+	return c.client.ScanPages(
+		&dynamodb.ScanInput{
+			ScanFilter: map[string]*dynamodb.Condition{
+				c.opts.KeyAttribute: &dynamodb.Condition{
+					AttributeValueList: []*dynamodb.AttributeValue{
+						&dynamodb.AttributeValue{S: aws.String(key)}},
+					ComparisonOperator: aws.String("BEGINS_WITH")}},
+			AttributesToGet: []*string{aws.String(c.opts.KeyAttribute), aws.String(c.opts.ValueAttribute)},
+			TableName:       aws.String(c.table),
+			Select:          aws.String("SPECIFIC_ATTRIBUTES"),
+		},
+		func(page *dynamodb.ScanOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				c.store(item, vars)
 			}
+			return true
+		})
+}
+
+// query reads the items of the partition whose key begins with key, page
+// by page, from the table or from the index.
+func (c *Client) query(key string, vars map[string]string) error {
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression: aws.String("#p = :p AND begins_with(#k, :k)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#p": aws.String(c.opts.PartitionKey),
+			"#k": aws.String(c.opts.KeyAttribute),
+			"#v": aws.String(c.opts.ValueAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": {S: aws.String(c.opts.PartitionValue)},
+			":k": {S: aws.String(key)},
+		},
+		ProjectionExpression: aws.String("#k, #v"),
+		TableName:            aws.String(c.table),
+	}
+	if c.opts.Index != "" {
+		input.IndexName = aws.String(c.opts.Index)
+	}
+	return c.client.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			c.store(item, vars)
 		}
+		return true
+	})
+}
+
+// store sets the value of item in vars under its key. Maps and lists are
+// flattened into nested keys, /key/field and /key/0 for instance.
+func (c *Client) store(item map[string]*dynamodb.AttributeValue, vars map[string]string) {
+	k, ok := item[c.opts.KeyAttribute]
+	if !ok || k.S == nil {
+		return
 	}
-	return vars, nil
+	val, ok := item[c.opts.ValueAttribute]
+	if !ok {
+		return
+	}
+	v, ok := convert(*k.S, val)
+	if !ok {
+		log.Warning("Skipping key '%s'. '%s' is not of a supported type.", *k.S, c.opts.ValueAttribute)
+		return
+	}
+	flatten.Walk(*k.S, v, vars)
 }
 
-// WatchPrefix is not implemented
-func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	<-stopChan
-	return 0, nil
+// convert returns the value of attribute v, stored under key, as the JSON
+// types flatten.Walk descends, and reports whether it is of a supported
+// type: S, N, BOOL, NULL, M or L. Numbers keep their text. Unsupported
+// items of a list are left out, the items after them move up.
+func convert(key string, v *dynamodb.AttributeValue) (interface{}, bool) {
+	switch {
+	case v.S != nil:
+		return *v.S, true
+	case v.N != nil:
+		return *v.N, true
+	case v.BOOL != nil:
+		return *v.BOOL, true
+	case v.NULL != nil:
+		return nil, true
+	case v.M != nil:
+		m := make(map[string]interface{}, len(v.M))
+		for field, item := range v.M {
+			if value, ok := convert(key+"/"+field, item); ok {
+				m[field] = value
+			} else {
+				log.Warning("Skipping key '%s/%s'. It is not of a supported type.", key, field)
+			}
+		}
+		return m, true
+	case v.L != nil:
+		l := make([]interface{}, 0, len(v.L))
+		for i, item := range v.L {
+			if value, ok := convert(key+"/"+strconv.Itoa(i), item); ok {
+				l = append(l, value)
+			} else {
+				log.Warning("Skipping key '%s/%d'. It is not of a supported type.", key, i)
+			}
+		}
+		return l, true
+	}
+	return nil, false
 }
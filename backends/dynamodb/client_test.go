@@ -0,0 +1,201 @@
+package dynamodb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDynamoDB serves a table of items in pages of one item, recording the
+// operations it is asked for. If stream is set, the table has a stream of a
+// single shard holding records.
+type fakeDynamoDB struct {
+	items  []map[string]interface{}
+	stream bool
+
+	records []map[string]interface{}
+
+	mu         sync.Mutex
+	operations []string
+	queries    []map[string]interface{}
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	target := r.Header.Get("X-Amz-Target")
+	op := target[strings.Index(target, ".")+1:]
+	f.operations = append(f.operations, op)
+	var in map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&in)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+	switch op {
+	case "DescribeTable":
+		json.NewEncoder(w).Encode(map[string]interface{}{"Table": map[string]interface{}{"TableName": "confd"}})
+	case "GetItem":
+		json.NewEncoder(w).Encode(map[string]interface{}{})
+	case "Scan", "Query":
+		if op == "Query" {
+			f.queries = append(f.queries, in)
+		}
+		// One item per page, the start key is the index of the next item.
+		start := 0
+		if k, ok := in["ExclusiveStartKey"].(map[string]interface{}); ok {
+			start, _ = strconv.Atoi(k["i"].(map[string]interface{})["N"].(string))
+		}
+		out := map[string]interface{}{"Items": f.items[start : start+1]}
+		if start+1 < len(f.items) {
+			out["LastEvaluatedKey"] = map[string]interface{}{"i": map[string]string{"N": strconv.Itoa(start + 1)}}
+		}
+		json.NewEncoder(w).Encode(out)
+	case "ListStreams":
+		streams := []map[string]string{}
+		if f.stream {
+			streams = append(streams, map[string]string{"StreamArn": "arn:confd"})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Streams": streams})
+	case "DescribeStream":
+		json.NewEncoder(w).Encode(map[string]interface{}{"StreamDescription": map[string]interface{}{
+			"Shards": []map[string]interface{}{{"ShardId": "s1", "SequenceNumberRange": map[string]string{"StartingSequenceNumber": "1"}}},
+		}})
+	case "GetShardIterator":
+		// The iterator is the index of the next record.
+		it := "0"
+		if in["ShardIteratorType"] == "LATEST" {
+			it = strconv.Itoa(len(f.records))
+		}
+		json.NewEncoder(w).Encode(map[string]string{"ShardIterator": it})
+	case "GetRecords":
+		i, _ := strconv.Atoi(in["ShardIterator"].(string))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Records":           f.records[i:],
+			"NextShardIterator": strconv.Itoa(len(f.records)),
+		})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// change records a change of the item of key in the stream.
+func (f *fakeDynamoDB) change(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records = append(f.records, map[string]interface{}{
+		"eventName": "MODIFY",
+		"dynamodb":  map[string]interface{}{"Keys": map[string]interface{}{"key": map[string]string{"S": key}}},
+	})
+}
+
+// newTestClient returns a client of f and the function stopping f.
+func newTestClient(t *testing.T, f *fakeDynamoDB, opts Options) (*Client, func()) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "foo")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "bar")
+	s := httptest.NewServer(f)
+	opts.Endpoint = s.URL
+	opts.Region = "eu-west-1"
+	c, err := NewDynamoDBClient("confd", opts)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return c, s.Close
+}
+
+func TestGetValuesScanPages(t *testing.T) {
+	f := &fakeDynamoDB{items: []map[string]interface{}{
+		{"k": map[string]string{"S": "/app/a"}, "v": map[string]string{"S": "1"}},
+		{"k": map[string]string{"S": "/app/b"}, "v": map[string]string{"S": "2"}},
+		{"k": map[string]string{"S": "/app/c"}, "v": map[string]string{"S": "3"}},
+	}}
+	c, stop := newTestClient(t, f, Options{KeyAttribute: "k", ValueAttribute: "v"})
+	defer stop()
+
+	vars, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"/app/a": "1", "/app/b": "2", "/app/c": "3"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+	if want := []string{"DescribeTable", "GetItem", "Scan", "Scan", "Scan"}; !reflect.DeepEqual(f.operations, want) {
+		t.Errorf("operations = %v, want %v", f.operations, want)
+	}
+}
+
+func TestGetValuesQuery(t *testing.T) {
+	f := &fakeDynamoDB{items: []map[string]interface{}{
+		{"key": map[string]string{"S": "/app/a"}, "value": map[string]string{"S": "1"}},
+		{"key": map[string]string{"S": "/app/b"}, "value": map[string]string{"S": "2"}},
+	}}
+	c, stop := newTestClient(t, f, Options{PartitionKey: "env", PartitionValue: "prod", Index: "env-key"})
+	defer stop()
+
+	vars, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"/app/a": "1", "/app/b": "2"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+	if want := []string{"DescribeTable", "Query", "Query"}; !reflect.DeepEqual(f.operations, want) {
+		t.Errorf("operations = %v, want %v", f.operations, want)
+	}
+	q := f.queries[0]
+	if q["IndexName"] != "env-key" || q["KeyConditionExpression"] != "#p = :p AND begins_with(#k, :k)" {
+		t.Errorf("query = %v, want a query of index env-key on the partition and key prefix", q)
+	}
+	values := q["ExpressionAttributeValues"].(map[string]interface{})
+	if values[":p"].(map[string]interface{})["S"] != "prod" || values[":k"].(map[string]interface{})["S"] != "/app" {
+		t.Errorf("query values = %v, want the partition prod and the prefix /app", values)
+	}
+}
+
+func TestGetValuesTypes(t *testing.T) {
+	var item map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"key": {"S": "/app"},
+		"value": {"M": {
+			"port": {"N": "8080"},
+			"debug": {"BOOL": false},
+			"proxy": {"NULL": true},
+			"hosts": {"L": [{"S": "a"}, {"M": {"name": {"S": "b"}}}]},
+			"tags": {"SS": ["x"]}
+		}}
+	}`), &item)
+	f := &fakeDynamoDB{items: []map[string]interface{}{item}}
+	c, stop := newTestClient(t, f, Options{})
+	defer stop()
+
+	vars, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/app/port":         "8080",
+		"/app/debug":        "false",
+		"/app/proxy":        "null",
+		"/app/hosts/0":      "a",
+		"/app/hosts/b/name": "b",
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+}
+
+func TestNewDynamoDBClientOptions(t *testing.T) {
+	if _, err := NewDynamoDBClient("confd", Options{PartitionKey: "env"}); err == nil {
+		t.Error("NewDynamoDBClient() accepted a partition key without value")
+	}
+	if _, err := NewDynamoDBClient("confd", Options{Index: "env-key"}); err == nil {
+		t.Error("NewDynamoDBClient() accepted an index without partition key")
+	}
+}
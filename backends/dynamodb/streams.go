@@ -0,0 +1,185 @@
+package dynamodb
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
+	"github.com/aws/aws-sdk-go/private/signer/v4"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// streams is a client of the DynamoDB Streams API, which the vendored SDK
+// predates. It only implements the operations needed to follow a table.
+type streams struct {
+	*client.Client
+}
+
+func newStreams(p client.ConfigProvider, cfgs ...*aws.Config) *streams {
+	c := p.ClientConfig("streams.dynamodb", cfgs...)
+	s := &streams{
+		Client: client.New(
+			*c.Config,
+			metadata.ClientInfo{
+				ServiceName:   "streams.dynamodb",
+				SigningName:   "dynamodb",
+				SigningRegion: c.SigningRegion,
+				Endpoint:      c.Endpoint,
+				APIVersion:    "2012-08-10",
+				JSONVersion:   "1.0",
+				TargetPrefix:  "DynamoDBStreams_20120810",
+			},
+			c.Handlers,
+		),
+	}
+	s.Handlers.Sign.PushBack(v4.Sign)
+	s.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	s.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	s.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	s.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+	return s
+}
+
+func (s *streams) call(name string, input, output interface{}) error {
+	op := &request.Operation{Name: name, HTTPMethod: "POST", HTTPPath: "/"}
+	return s.NewRequest(op, input, output).Send()
+}
+
+type listStreamsInput struct {
+	TableName *string
+}
+
+type listStreamsOutput struct {
+	Streams []*struct {
+		StreamArn *string
+	}
+}
+
+// latestStream returns the ARN of the enabled stream of table, or "" if the
+// table has no stream.
+func (s *streams) latestStream(table string) (string, error) {
+	var out listStreamsOutput
+	if err := s.call("ListStreams", &listStreamsInput{TableName: aws.String(table)}, &out); err != nil {
+		return "", err
+	}
+	for _, stream := range out.Streams {
+		if stream.StreamArn != nil {
+			return *stream.StreamArn, nil
+		}
+	}
+	return "", nil
+}
+
+type describeStreamInput struct {
+	StreamArn             *string
+	ExclusiveStartShardId *string
+}
+
+type describeStreamOutput struct {
+	StreamDescription *struct {
+		StreamStatus         *string
+		Shards               []*shard
+		LastEvaluatedShardId *string
+	}
+}
+
+type shard struct {
+	ShardId             *string
+	SequenceNumberRange *struct {
+		EndingSequenceNumber *string
+	}
+}
+
+// closed reports whether the shard no longer receives records.
+func (sh *shard) closed() bool {
+	return sh.SequenceNumberRange != nil && sh.SequenceNumberRange.EndingSequenceNumber != nil
+}
+
+// shards returns all the shards of stream arn, page by page.
+func (s *streams) shards(arn string) ([]*shard, error) {
+	var shards []*shard
+	in := &describeStreamInput{StreamArn: aws.String(arn)}
+	for {
+		var out describeStreamOutput
+		if err := s.call("DescribeStream", in, &out); err != nil {
+			return nil, err
+		}
+		if out.StreamDescription == nil {
+			return shards, nil
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+		if out.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		in.ExclusiveStartShardId = out.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+type getShardIteratorInput struct {
+	StreamArn         *string
+	ShardId           *string
+	ShardIteratorType *string
+}
+
+type getShardIteratorOutput struct {
+	ShardIterator *string
+}
+
+// iterator returns an iterator of shard id of stream arn, starting at the
+// oldest record (TRIM_HORIZON) or after the newest one (LATEST).
+func (s *streams) iterator(arn, id, position string) (*string, error) {
+	var out getShardIteratorOutput
+	in := &getShardIteratorInput{
+		StreamArn:         aws.String(arn),
+		ShardId:           aws.String(id),
+		ShardIteratorType: aws.String(position),
+	}
+	if err := s.call("GetShardIterator", in, &out); err != nil {
+		return nil, err
+	}
+	return out.ShardIterator, nil
+}
+
+type getRecordsInput struct {
+	ShardIterator *string
+}
+
+type getRecordsOutput struct {
+	Records           []*streamRecord
+	NextShardIterator *string
+}
+
+// streamRecord is a change of an item. Depending on the view type of the
+// stream, it holds the item before and after the change.
+type streamRecord struct {
+	Dynamodb *struct {
+		Keys     map[string]*dynamodb.AttributeValue
+		NewImage map[string]*dynamodb.AttributeValue
+		OldImage map[string]*dynamodb.AttributeValue
+	} `locationName:"dynamodb"`
+}
+
+// attribute returns attribute name of the changed item, or nil if the
+// record does not hold it.
+func (r *streamRecord) attribute(name string) *dynamodb.AttributeValue {
+	if r.Dynamodb == nil {
+		return nil
+	}
+	for _, item := range []map[string]*dynamodb.AttributeValue{r.Dynamodb.Keys, r.Dynamodb.NewImage, r.Dynamodb.OldImage} {
+		if v, ok := item[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// records returns the records available at iterator, and the iterator
+// of the next ones, nil once the shard is closed and fully read.
+func (s *streams) records(iterator *string) ([]*streamRecord, *string, error) {
+	var out getRecordsOutput
+	if err := s.call("GetRecords", &getRecordsInput{ShardIterator: iterator}, &out); err != nil {
+		return nil, nil, err
+	}
+	return out.Records, out.NextShardIterator, nil
+}
@@ -0,0 +1,178 @@
+package dynamodb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/confd/backends/changes"
+	"github.com/kelseyhightower/confd/log"
+)
+
+const (
+	// recordsInterval is how often each shard is read, the stream allows
+	// a few reads per second and shard.
+	recordsInterval = time.Second
+	// shardsInterval is how often the stream is checked for new shards.
+	shardsInterval = time.Minute
+	// retryDelay is the delay before following the stream again.
+	retryDelay = 5 * time.Second
+	// followTimeout bounds the wait for the stream to be followed before
+	// the first watch returns.
+	followTimeout = 10 * time.Second
+	// defaultPollInterval is how often the values are read again when the
+	// table has no stream.
+	defaultPollInterval = 30 * time.Second
+)
+
+// watcher turns the records of the stream of the table into an increasing
+// index.
+type watcher struct {
+	*changes.Log
+	client *Client
+	arn    string
+	// ready is closed once the shards of the stream are followed.
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// startWatcher follows the stream of the table, if it has one. Otherwise
+// the watches poll the table.
+func (c *Client) startWatcher() {
+	arn, err := c.streams.latestStream(c.table)
+	if err != nil {
+		log.Warning("cannot find the stream of table %s, polling every %s: %s", c.table, c.opts.PollInterval, err)
+		return
+	}
+	if arn == "" {
+		log.Info("table %s has no stream, polling every %s", c.table, c.opts.PollInterval)
+		return
+	}
+	log.Info("following stream %s", arn)
+	c.watcher = &watcher{
+		Log:    changes.NewLog(),
+		client: c,
+		arn:    arn,
+		ready:  make(chan struct{}),
+	}
+	go c.watcher.run()
+	select {
+	case <-c.watcher.ready:
+	case <-time.After(followTimeout):
+		log.Warning("stream %s is not followed yet", arn)
+	}
+}
+
+// run follows the stream until the process exits.
+func (w *watcher) run() {
+	resumed := false
+	for {
+		err := w.follow(resumed)
+		log.Error("cannot follow stream %s: %s", w.arn, err)
+		resumed = true
+		time.Sleep(retryDelay)
+	}
+}
+
+// follow reads the records of all the shards of the stream, starting with
+// the records written from now on. Shards opened later are read from their
+// first record, so that no change is missed when shards split.
+func (w *watcher) follow(resumed bool) error {
+	streams := w.client.streams
+	known := make(map[string]bool)
+	iterators := make(map[string]*string)
+	refresh := func(position string) error {
+		shards, err := streams.shards(w.arn)
+		if err != nil {
+			return err
+		}
+		for _, sh := range shards {
+			id := *sh.ShardId
+			if known[id] {
+				continue
+			}
+			known[id] = true
+			if sh.closed() && position == "LATEST" {
+				continue
+			}
+			it, err := streams.iterator(w.arn, id, position)
+			if err != nil {
+				return err
+			}
+			iterators[id] = it
+		}
+		return nil
+	}
+
+	if err := refresh("LATEST"); err != nil {
+		return err
+	}
+	w.readyOnce.Do(func() { close(w.ready) })
+	if resumed {
+		// Changes may have been missed since the last record read.
+		w.Notify("")
+	}
+	refreshed := time.Now()
+	for {
+		time.Sleep(recordsInterval)
+		closed := false
+		for id, it := range iterators {
+			records, next, err := streams.records(it)
+			if err != nil {
+				return err
+			}
+			for _, r := range records {
+				w.record(r)
+			}
+			if next == nil {
+				delete(iterators, id)
+				closed = true
+			} else {
+				iterators[id] = next
+			}
+		}
+		if closed || time.Since(refreshed) > shardsInterval {
+			if err := refresh("TRIM_HORIZON"); err != nil {
+				return err
+			}
+			refreshed = time.Now()
+		}
+	}
+}
+
+// record notifies the change of the item of r.
+func (w *watcher) record(r *streamRecord) {
+	opts := w.client.opts
+	if opts.PartitionKey != "" {
+		p := r.attribute(opts.PartitionKey)
+		if p != nil && p.S != nil && *p.S != opts.PartitionValue {
+			return
+		}
+	}
+	k := r.attribute(opts.KeyAttribute)
+	if k == nil || k.S == nil {
+		// The key is not part of the record, any key may have changed.
+		w.Notify("")
+		return
+	}
+	w.Notify(*k.S)
+}
+
+// WatchPrefix returns when an item below keys changed, according to the
+// stream of the table. Tables without stream are polled instead.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	c.watchOnce.Do(c.startWatcher)
+	if c.watcher == nil {
+		return c.poll(keys, waitIndex, stopChan)
+	}
+	if waitIndex == 0 {
+		return c.watcher.Current(), nil
+	}
+	return c.watcher.Wait(keys, waitIndex, stopChan), nil
+}
+
+// poll reads the values of keys every poll interval until they differ
+// from the ones of waitIndex. The index is a checksum of the values.
+func (c *Client) poll(keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	get := func() (map[string]string, error) { return c.GetValues(keys) }
+	return changes.Poll(get, c.opts.PollInterval, waitIndex, stopChan)
+}
@@ -0,0 +1,72 @@
+package dynamodb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchPrefixStream(t *testing.T) {
+	f := &fakeDynamoDB{stream: true, items: []map[string]interface{}{
+		{"key": map[string]string{"S": "/app/a"}, "value": map[string]string{"S": "1"}},
+	}}
+	c, stop := newTestClient(t, f, Options{})
+	defer stop()
+	stopChan := make(chan bool)
+
+	index, err := c.WatchPrefix("/", []string{"/app"}, 0, stopChan)
+	if err != nil || index == 0 {
+		t.Fatalf("WatchPrefix() = %d, %v, want an index right away", index, err)
+	}
+	if c.watcher == nil {
+		t.Fatal("WatchPrefix() polls a table with a stream")
+	}
+
+	// Only the second change is below /app.
+	f.change("/other/a")
+	f.change("/app/a")
+	next, err := c.WatchPrefix("/", []string{"/app"}, index, stopChan)
+	if err != nil || next != index+2 {
+		t.Fatalf("WatchPrefix() = %d, %v, want %d", next, err, index+2)
+	}
+
+	go func() {
+		time.Sleep(2 * recordsInterval)
+		close(stopChan)
+	}()
+	if again, err := c.WatchPrefix("/", []string{"/app"}, next, stopChan); err != nil || again != next {
+		t.Errorf("WatchPrefix() = %d, %v, want %d once stopped", again, err, next)
+	}
+}
+
+func TestWatchPrefixPoll(t *testing.T) {
+	f := &fakeDynamoDB{items: []map[string]interface{}{
+		{"key": map[string]string{"S": "/app/a"}, "value": map[string]string{"S": "1"}},
+	}}
+	c, stop := newTestClient(t, f, Options{PollInterval: 10 * time.Millisecond})
+	defer stop()
+	stopChan := make(chan bool)
+	defer close(stopChan)
+
+	index, err := c.WatchPrefix("/", []string{"/app"}, 0, stopChan)
+	if err != nil || index == 0 {
+		t.Fatalf("WatchPrefix() = %d, %v, want an index right away", index, err)
+	}
+	if c.watcher != nil {
+		t.Fatal("WatchPrefix() follows a table without stream")
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		f.mu.Lock()
+		f.items[0]["value"] = map[string]string{"S": "2"}
+		f.mu.Unlock()
+	}()
+	start := time.Now()
+	next, err := c.WatchPrefix("/", []string{"/app"}, index, stopChan)
+	if err != nil || next == index {
+		t.Fatalf("WatchPrefix() = %d, %v, want a new index", next, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("WatchPrefix() returned after %s, before the value changed", elapsed)
+	}
+}
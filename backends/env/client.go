@@ -1,59 +1,239 @@
 package env
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/kelseyhightower/confd/backends"
 	"github.com/kelseyhightower/confd/log"
 )
 
-var replacer = strings.NewReplacer("/", "_")
+// Options are the env specific settings, set as backend options.
+type Options struct {
+	// Separator stands for "/" in the variable names. With "__", the
+	// variable DB__MAX_CONNS holds the key /db/max_conns.
+	Separator string `mapstructure:"separator"`
+	// PreserveCase keeps the case of the keys in the variable names, and
+	// of the variable names in the keys, instead of upper-casing the
+	// former and lower-casing the latter.
+	PreserveCase bool `mapstructure:"preserve_case"`
+	// EnvFiles are .env files of KEY=VALUE lines read before the
+	// environment, later files overriding earlier ones.
+	EnvFiles []string `mapstructure:"env_files"`
+	// SecretsDirs are directories, such as /run/secrets, whose files are
+	// variables named after the file and holding its content. They
+	// override the .env files.
+	SecretsDirs []string `mapstructure:"secrets_dirs"`
+	// PollInterval is how often the .env files and the secrets are read
+	// again to watch them.
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+}
 
 // Client provides a shell for the env client
-type Client struct{}
+type Client struct {
+	opts Options
+}
 
-// NewEnvClient returns a new client
-func NewEnvClient() (*Client, error) {
-	return &Client{}, nil
+func init() {
+	backends.Register("env", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return NewEnvClient(opts)
+	})
 }
 
-// GetValues queries the environment for keys
-func (c *Client) GetValues(keys []string) (map[string]string, error) {
-	allEnvVars := os.Environ()
+// NewEnvClient returns a client reading the environment, the .env files
+// and the secrets directories of opts.
+func NewEnvClient(opts Options) (*Client, error) {
+	if opts.Separator == "" {
+		opts.Separator = "_"
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	for _, p := range opts.EnvFiles {
+		if _, err := os.Stat(p); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range opts.SecretsDirs {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("secrets directory %s is not a directory", p)
+		}
+	}
+	return &Client{opts}, nil
+}
+
+// environ returns the variables of the .env files, the secrets directories
+// and the environment, in increasing order of precedence. The names are
+// folded, so that a secret named db_password overrides DB_PASSWORD unless
+// the case is preserved.
+func (c *Client) environ() (map[string]string, error) {
 	envMap := make(map[string]string)
-	for _, e := range allEnvVars {
+	add := func(vars map[string]string) {
+		for name, value := range vars {
+			envMap[c.fold(name)] = value
+		}
+	}
+	for _, p := range c.opts.EnvFiles {
+		vars := make(map[string]string)
+		if err := readEnvFile(p, vars); err != nil {
+			return nil, err
+		}
+		add(vars)
+	}
+	for _, p := range c.opts.SecretsDirs {
+		vars := make(map[string]string)
+		if err := readSecrets(p, vars); err != nil {
+			return nil, err
+		}
+		add(vars)
+	}
+	vars := make(map[string]string)
+	for _, e := range os.Environ() {
 		index := strings.Index(e, "=")
-		envMap[e[:index]] = e[index+1:]
+		vars[e[:index]] = e[index+1:]
+	}
+	add(vars)
+	return envMap, nil
+}
+
+// GetValues queries the environment for keys
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	envMap, err := c.environ()
+	if err != nil {
+		return nil, err
 	}
 	vars := make(map[string]string)
 	for _, key := range keys {
-		k := transform(key)
+		k := c.transform(key)
 		for envKey, envValue := range envMap {
 			if strings.HasPrefix(envKey, k) {
-				vars[clean(envKey)] = envValue
+				vars[c.clean(envKey)] = envValue
 			}
 		}
 	}
 
-	log.Debug(fmt.Sprintf("Key Map: %#v", vars))
+	log.Debug("Key Map: %#v", vars)
 
 	return vars, nil
 }
 
-func transform(key string) string {
+// fold returns name as it is compared with the transformed keys.
+func (c *Client) fold(name string) string {
+	if c.opts.PreserveCase {
+		return name
+	}
+	return strings.ToUpper(name)
+}
+
+// transform returns the variable name of key.
+func (c *Client) transform(key string) string {
 	k := strings.TrimPrefix(key, "/")
-	return strings.ToUpper(replacer.Replace(k))
+	return c.fold(strings.Replace(k, "/", c.opts.Separator, -1))
 }
 
-var cleanReplacer = strings.NewReplacer("_", "/")
+// clean returns the key of the variable name.
+func (c *Client) clean(name string) string {
+	if !c.opts.PreserveCase {
+		name = strings.ToLower(name)
+	}
+	return "/" + strings.Replace(name, c.opts.Separator, "/", -1)
+}
 
-func clean(key string) string {
-	newKey := "/" + key
-	return cleanReplacer.Replace(strings.ToLower(newKey))
+// readEnvFile adds the variables of the .env file at path to vars. Lines
+// are KEY=VALUE, optionally preceded by export, with blank lines and lines
+// starting with # ignored. Values may be quoted: double quoted values are
+// unescaped, single quoted ones are taken as is, and a # preceded by a
+// space starts a comment after an unquoted value.
+func readEnvFile(path string, vars map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i < 1 {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value, err := parseValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		vars[strings.TrimSpace(line[:i])] = value
+	}
+	return s.Err()
 }
 
-func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	<-stopChan
-	return 0, nil
+func parseValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := strings.LastIndex(v, `"`)
+		if end == 0 {
+			return "", errors.New("unterminated double quoted value")
+		}
+		return strconv.Unquote(v[:end+1])
+	case strings.HasPrefix(v, "'"):
+		end := strings.LastIndex(v, "'")
+		if end == 0 {
+			return "", errors.New("unterminated single quoted value")
+		}
+		return v[1:end], nil
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v, nil
+}
+
+// readSecrets adds the files of dir to vars, named after the files and
+// holding their content without the trailing newline. Hidden files, such
+// as the ..data links of Kubernetes, and directories are skipped.
+func readSecrets(dir string, vars map[string]string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		// Follow the links to the files, as mounted by Kubernetes.
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if fi, err = os.Stat(path); err != nil {
+				return err
+			}
+		}
+		if fi.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		vars[fi.Name()] = strings.TrimRight(string(data), "\r\n")
+	}
+	return nil
 }
@@ -0,0 +1,157 @@
+package env
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetValuesSeparator(t *testing.T) {
+	os.Setenv("CONFD_TEST_DB__MAX_CONNS", "10")
+	os.Setenv("CONFD_TEST_DB__HOST", "127.0.0.1")
+	defer os.Unsetenv("CONFD_TEST_DB__MAX_CONNS")
+	defer os.Unsetenv("CONFD_TEST_DB__HOST")
+
+	c, err := NewEnvClient(Options{Separator: "__"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetValues([]string{"/confd_test_db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/confd_test_db/max_conns": "10",
+		"/confd_test_db/host":      "127.0.0.1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+}
+
+func TestGetValuesPreserveCase(t *testing.T) {
+	os.Setenv("confdTest_dbHost", "127.0.0.1")
+	os.Setenv("CONFDTEST_DBPORT", "3306")
+	defer os.Unsetenv("confdTest_dbHost")
+	defer os.Unsetenv("CONFDTEST_DBPORT")
+
+	c, err := NewEnvClient(Options{PreserveCase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetValues([]string{"/confdTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"/confdTest/dbHost": "127.0.0.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+}
+
+func TestGetValuesFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "confd-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	envFile := filepath.Join(dir, ".env")
+	writeFile(t, envFile, `# database
+CONFDTEST_HOST=db.example.com
+export CONFDTEST_USER = confd # inline comment
+CONFDTEST_PASSWORD="p@ss\"w0rd"
+CONFDTEST_GREETING='hello # world'
+CONFDTEST_PORT=3306
+`)
+	secrets := filepath.Join(dir, "secrets")
+	if err := os.Mkdir(secrets, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(secrets, "confdtest_password"), "s3cr3t\n")
+	if err := os.Mkdir(filepath.Join(secrets, "..data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CONFDTEST_PORT", "5432")
+	defer os.Unsetenv("CONFDTEST_PORT")
+
+	c, err := NewEnvClient(Options{EnvFiles: []string{envFile}, SecretsDirs: []string{secrets}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetValues([]string{"/confdtest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The secrets override the .env file, and the environment both.
+	want := map[string]string{
+		"/confdtest/host":     "db.example.com",
+		"/confdtest/user":     "confd",
+		"/confdtest/password": "s3cr3t",
+		"/confdtest/greeting": "hello # world",
+		"/confdtest/port":     "5432",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+
+	writeFile(t, envFile, "CONFDTEST_HOST\n")
+	if _, err := c.GetValues([]string{"/confdtest"}); err == nil {
+		t.Error("GetValues() accepted a line without =")
+	}
+	if _, err := NewEnvClient(Options{SecretsDirs: []string{envFile}}); err == nil {
+		t.Error("NewEnvClient() accepted a file as secrets directory")
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "confd-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := filepath.Join(dir, "confdtest_password")
+	writeFile(t, secret, "one")
+
+	c, err := NewEnvClient(Options{SecretsDirs: []string{dir}, PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopChan := make(chan bool)
+	index, err := c.WatchPrefix("/", []string{"/confdtest"}, 0, stopChan)
+	if err != nil || index == 0 {
+		t.Fatalf("WatchPrefix() = %d, %v, want an index right away", index, err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		writeFile(t, secret, "two")
+	}()
+	next, err := c.WatchPrefix("/", []string{"/confdtest"}, index, stopChan)
+	if err != nil || next == index {
+		t.Fatalf("WatchPrefix() = %d, %v, want a new index", next, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if i, err := c.WatchPrefix("/", []string{"/confdtest"}, next, stopChan); i != next || err != nil {
+			t.Errorf("WatchPrefix() = %d, %v after stop, want %d, nil", i, err, next)
+		}
+	}()
+	close(stopChan)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WatchPrefix() did not return after stop")
+	}
+}
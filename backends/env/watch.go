@@ -0,0 +1,29 @@
+package env
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/confd/backends/changes"
+)
+
+// defaultPollInterval is how often the .env files and the secrets are read
+// again, unless the poll_interval option is set.
+const defaultPollInterval = time.Second
+
+// WatchPrefix reads the .env files and the secrets directories every poll
+// interval until the values of keys change, and returns their checksum as
+// index. The environment of the process cannot change, so without files it
+// blocks until stopChan fires.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if len(c.opts.EnvFiles) == 0 && len(c.opts.SecretsDirs) == 0 {
+		// return something > 0 to trigger a key retrieval from the store
+		if waitIndex == 0 {
+			return 1, nil
+		}
+		<-stopChan
+		return waitIndex, nil
+	}
+
+	get := func() (map[string]string, error) { return c.GetValues(keys) }
+	return changes.Poll(get, c.opts.PollInterval, waitIndex, stopChan)
+}
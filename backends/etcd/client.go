@@ -6,10 +6,12 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/coreos/etcd/client"
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/flatten"
+	"github.com/kelseyhightower/confd/log"
 	"golang.org/x/net/context"
 )
 
@@ -18,6 +20,14 @@ type Client struct {
 	client client.KeysAPI
 }
 
+func init() {
+	backends.Register("etcd", func(config backends.Config) (backends.StoreClient, error) {
+		// Create the etcd client upfront and use it for the life of the process.
+		// The etcdClient is an http.Client and designed to be reused.
+		return NewEtcdClient(config.BackendNodes, config.ClientCert, config.ClientKey, config.ClientCaKeys, config.BasicAuth, config.Username, config.Password)
+	})
+}
+
 // NewEtcdClient returns an *etcd.Client with a connection to named machines.
 func NewEtcdClient(machines []string, cert, key, caCert string, basicAuth bool, username string, password string) (*Client, error) {
 	var c client.Client
@@ -115,48 +125,70 @@ func nodeWalk(node *client.Node, vars map[string]string) error {
 	return nil
 }
 
+// currentIndex returns the index of etcd, read along with prefix, which
+// need not exist.
+func (c *Client) currentIndex(ctx context.Context, prefix string) (uint64, error) {
+	resp, err := c.client.Get(ctx, prefix, &client.GetOptions{Quorum: true})
+	if err != nil {
+		if e, ok := err.(client.Error); ok && e.Code == client.ErrorCodeKeyNotFound {
+			return e.Index, nil
+		}
+		return 0, err
+	}
+	return resp.Index, nil
+}
+
+// WatchPrefix waits for a change of keys after waitIndex, and returns the
+// index of the change. Changes made since waitIndex, while the templates
+// were rendered, are not lost. If etcd no longer holds the events after
+// waitIndex, the current index is returned so that every key is read
+// again.
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	// return something > 0 to trigger a key retrieval from the store
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// return something > 0 to trigger a key retrieval from the store,
+	// which the watch resumes from.
 	if waitIndex == 0 {
-		return 1, nil
+		index, err := c.currentIndex(ctx, prefix)
+		if ctx.Err() != nil {
+			return waitIndex, nil
+		}
+		if err != nil {
+			return waitIndex, err
+		}
+		if index == 0 {
+			index = 1
+		}
+		return index, nil
 	}
 
+	watcher := c.client.Watcher(prefix, &client.WatcherOptions{AfterIndex: waitIndex, Recursive: true})
 	for {
-		// Setting AfterIndex to 0 (default) means that the Watcher
-		// should start watching for events starting at the current
-		// index, whatever that may be.
-		watcher := c.client.Watcher(prefix, &client.WatcherOptions{AfterIndex: uint64(0), Recursive: true})
-		ctx, cancel := context.WithCancel(context.Background())
-		cancelRoutine := make(chan bool)
-		defer close(cancelRoutine)
-
-		go func() {
-			select {
-			case <-stopChan:
-				cancel()
-			case <-cancelRoutine:
-				return
-			}
-		}()
-
 		resp, err := watcher.Next(ctx)
+		if ctx.Err() != nil {
+			return waitIndex, nil
+		}
 		if err != nil {
-			switch e := err.(type) {
-			case *client.Error:
-				if e.Code == 401 {
-					return 0, nil
-				}
+			if e, ok := err.(client.Error); ok && e.Code == client.ErrorCodeEventIndexCleared {
+				log.Warning("etcd cleared the events after index %d, reading %s again at index %d", waitIndex, prefix, e.Index)
+				return e.Index, nil
 			}
 			return waitIndex, err
 		}
 
-		// Only return if we have a key prefix we care about.
-		// This is not an exact match on the key so there is a chance
-		// we will still pickup on false positives. The net win here
-		// is reducing the scope of keys that can trigger updates.
+		// Only return if a key is changed, or a directory holding one,
+		// such as a deleted or expired directory.
 		for _, k := range keys {
-			if strings.HasPrefix(resp.Node.Key, k) {
-				return resp.Node.ModifiedIndex, err
+			if flatten.IsChild(resp.Node.Key, k) || flatten.IsChild(k, resp.Node.Key) {
+				return resp.Node.ModifiedIndex, nil
 			}
 		}
 	}
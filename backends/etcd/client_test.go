@@ -0,0 +1,192 @@
+package etcd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type node struct {
+	Key           string `json:"key"`
+	Value         string `json:"value,omitempty"`
+	Dir           bool   `json:"dir,omitempty"`
+	ModifiedIndex uint64 `json:"modifiedIndex"`
+	CreatedIndex  uint64 `json:"createdIndex"`
+}
+
+// fakeKeys is a minimal in-memory stand-in for the etcd v2 keys API.
+type fakeKeys struct {
+	mu      sync.Mutex
+	index   uint64
+	nodes   map[string]node
+	history []node
+	// cleared is the last index whose event etcd no longer holds.
+	cleared uint64
+}
+
+func newFakeKeys() *fakeKeys {
+	return &fakeKeys{index: 1, nodes: make(map[string]node)}
+}
+
+func (f *fakeKeys) set(key, value string) uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	n := node{Key: key, Value: value, ModifiedIndex: f.index, CreatedIndex: f.index}
+	f.nodes[key] = n
+	f.history = append(f.history, n)
+	return f.index
+}
+
+// under reports whether k is key or lives below it, as etcd matches
+// recursive watches.
+func under(k, key string) bool {
+	return k == key || strings.HasPrefix(k, strings.TrimSuffix(key, "/")+"/")
+}
+
+func (f *fakeKeys) fail(w http.ResponseWriter, status, code int) {
+	w.Header().Set("X-Etcd-Index", strconv.FormatUint(f.index, 10))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errorCode": code,
+		"message":   http.StatusText(status),
+		"index":     f.index,
+	})
+}
+
+func (f *fakeKeys) reply(w http.ResponseWriter, action string, n node) {
+	w.Header().Set("X-Etcd-Index", strconv.FormatUint(f.index, 10))
+	json.NewEncoder(w).Encode(map[string]interface{}{"action": action, "node": n})
+}
+
+func (f *fakeKeys) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v2/keys")
+	if r.URL.Query().Get("wait") != "true" {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if n, ok := f.nodes[key]; ok {
+			f.reply(w, "get", n)
+			return
+		}
+		for k := range f.nodes {
+			if under(k, key) {
+				f.reply(w, "get", node{Key: key, Dir: true})
+				return
+			}
+		}
+		f.fail(w, http.StatusNotFound, 100)
+		return
+	}
+
+	waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("waitIndex"), 10, 64)
+	for {
+		f.mu.Lock()
+		if waitIndex != 0 && waitIndex <= f.cleared {
+			f.fail(w, http.StatusBadRequest, 401)
+			f.mu.Unlock()
+			return
+		}
+		for _, n := range f.history {
+			if n.ModifiedIndex >= waitIndex && under(n.Key, key) {
+				f.reply(w, "set", n)
+				f.mu.Unlock()
+				return
+			}
+		}
+		f.mu.Unlock()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func newTestClient(t *testing.T, f *fakeKeys) (*Client, func()) {
+	ts := httptest.NewServer(f)
+	c, err := NewEtcdClient([]string{ts.URL}, "", "", "", false, "", "")
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return c, ts.Close
+}
+
+// watch runs WatchPrefix and fails the test if it does not return in time.
+func watch(t *testing.T, c *Client, prefix string, keys []string, waitIndex uint64) uint64 {
+	type result struct {
+		index uint64
+		err   error
+	}
+	stop := make(chan bool)
+	done := make(chan result, 1)
+	go func() {
+		index, err := c.WatchPrefix(prefix, keys, waitIndex, stop)
+		done <- result{index, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		return r.index
+	case <-time.After(2 * time.Second):
+		close(stop)
+		t.Fatalf("WatchPrefix(%q, %d) did not return", prefix, waitIndex)
+		return 0
+	}
+}
+
+func TestWatchPrefixReturnsChangesBetweenCalls(t *testing.T) {
+	f := newFakeKeys()
+	f.set("/app/db/host", "127.0.0.1")
+	c, stop := newTestClient(t, f)
+	defer stop()
+
+	keys := []string{"/app/db"}
+	index := watch(t, c, "/app", keys, 0)
+	if index != f.index {
+		t.Fatalf("WatchPrefix() = %d, want the current index %d", index, f.index)
+	}
+
+	// The templates are rendered when the key changes, the next call must
+	// still return the change.
+	changed := f.set("/app/db/host", "10.0.0.1")
+	if got := watch(t, c, "/app", keys, index); got != changed {
+		t.Errorf("WatchPrefix() = %d, want %d", got, changed)
+	}
+}
+
+func TestWatchPrefixIndexCleared(t *testing.T) {
+	f := newFakeKeys()
+	for i := 0; i < 5; i++ {
+		f.set("/app/db/host", strconv.Itoa(i))
+	}
+	f.cleared = 4
+	c, stop := newTestClient(t, f)
+	defer stop()
+
+	if got := watch(t, c, "/app", []string{"/app/db"}, 2); got != f.index {
+		t.Errorf("WatchPrefix() = %d, want the current index %d", got, f.index)
+	}
+}
+
+func TestWatchPrefixMatchesKeysExactly(t *testing.T) {
+	f := newFakeKeys()
+	f.set("/app/db/host", "127.0.0.1")
+	c, stop := newTestClient(t, f)
+	defer stop()
+
+	keys := []string{"/app/db"}
+	index := watch(t, c, "/app", keys, 0)
+	f.set("/app/dbx", "other")
+	changed := f.set("/app/db/port", "3306")
+	if got := watch(t, c, "/app", keys, index); got != changed {
+		t.Errorf("WatchPrefix() = %d, want %d of /app/db/port, not the change of /app/dbx", got, changed)
+	}
+}
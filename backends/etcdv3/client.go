@@ -0,0 +1,388 @@
+package etcdv3
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/flatten"
+	"github.com/kelseyhightower/confd/log"
+)
+
+// apiPrefix is the path under which the etcd gRPC gateway serves the v3 API.
+const apiPrefix = "/v3"
+
+// Client is a wrapper around the etcd v3 JSON gateway
+type Client struct {
+	endpoints  []string
+	httpClient *http.Client
+	basicAuth  bool
+	username   string
+	password   string
+
+	mu      sync.Mutex
+	current int
+	token   string
+}
+
+func init() {
+	backends.Register("etcdv3", func(config backends.Config) (backends.StoreClient, error) {
+		return NewEtcdClient(config.BackendNodes, config.ClientCert, config.ClientKey, config.ClientCaKeys, config.BasicAuth, config.Username, config.Password)
+	})
+}
+
+// NewEtcdClient returns an *etcdv3.Client with a connection to named machines.
+func NewEtcdClient(machines []string, cert, key, caCert string, basicAuth bool, username string, password string) (*Client, error) {
+	if len(machines) == 0 {
+		return nil, errors.New("no etcd v3 endpoints configured")
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: false,
+	}
+
+	if caCert != "" {
+		certBytes, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+
+		caCertPool := x509.NewCertPool()
+		ok := caCertPool.AppendCertsFromPEM(certBytes)
+
+		if ok {
+			tlsConfig.RootCAs = caCertPool
+		}
+	}
+
+	if cert != "" && key != "" {
+		tlsCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}
+
+	endpoints := make([]string, 0, len(machines))
+	for _, m := range machines {
+		if !strings.Contains(m, "://") {
+			m = "http://" + m
+		}
+		endpoints = append(endpoints, strings.TrimRight(m, "/"))
+	}
+
+	c := &Client{
+		endpoints:  endpoints,
+		httpClient: &http.Client{Transport: transport},
+		basicAuth:  basicAuth,
+		username:   username,
+		password:   password,
+	}
+	if basicAuth {
+		if err := c.authenticate(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+type responseHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+type keyValue struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type rangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+	Limit    int64  `json:"limit,omitempty,string"`
+}
+
+type rangeResponse struct {
+	Header responseHeader `json:"header"`
+	Kvs    []keyValue     `json:"kvs"`
+}
+
+type watchCreateRequest struct {
+	Key           []byte `json:"key"`
+	RangeEnd      []byte `json:"range_end,omitempty"`
+	StartRevision int64  `json:"start_revision,omitempty,string"`
+}
+
+type watchEvent struct {
+	Type string   `json:"type"`
+	Kv   keyValue `json:"kv"`
+}
+
+type watchResponse struct {
+	Result struct {
+		Header          responseHeader `json:"header"`
+		Created         bool           `json:"created"`
+		Canceled        bool           `json:"canceled"`
+		CompactRevision int64          `json:"compact_revision,string"`
+		CancelReason    string         `json:"cancel_reason"`
+		Events          []watchEvent   `json:"events"`
+	} `json:"result"`
+	Error *gatewayError `json:"error"`
+}
+
+type gatewayError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *gatewayError) Error() string {
+	return fmt.Sprintf("etcd: %s (code %d)", e.Message, e.Code)
+}
+
+// errUnauthorized is returned when the gateway rejects the auth token.
+var errUnauthorized = errors.New("etcd: unauthorized")
+
+// prefixEnd returns the range end that selects every key starting with
+// prefix, the same way clientv3.GetPrefixRangeEnd does.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// prefix is all 0xff, select every key >= prefix
+	return []byte{0}
+}
+
+// rangeFor returns the key range covering key and all of its children.
+func rangeFor(key string) ([]byte, []byte) {
+	if key == "" || key == "/" {
+		return []byte{0}, []byte{0}
+	}
+	return []byte(key), prefixEnd(key)
+}
+
+func (c *Client) authenticate(ctx context.Context) error {
+	body := map[string]string{"name": c.username, "password": c.password}
+	resp, err := c.post(ctx, "/auth/authenticate", body, false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.token = auth.Token
+	c.mu.Unlock()
+	return nil
+}
+
+// post sends a JSON request to the first endpoint that answers, starting
+// with the one that answered last time.
+func (c *Client) post(ctx context.Context, path string, v interface{}, withToken bool) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	start, token := c.current, c.token
+	c.mu.Unlock()
+
+	var lastErr error
+	for i := 0; i < len(c.endpoints); i++ {
+		idx := (start + i) % len(c.endpoints)
+		req, err := http.NewRequest("POST", c.endpoints[idx]+apiPrefix+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if withToken && token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		resp, err := c.httpClient.Do(req.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Debug("etcd endpoint %s failed: %v", c.endpoints[idx], err)
+			lastErr = err
+			continue
+		}
+
+		c.mu.Lock()
+		c.current = idx
+		c.mu.Unlock()
+
+		if resp.StatusCode == http.StatusUnauthorized {
+			resp.Body.Close()
+			return nil, errUnauthorized
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			gerr := &gatewayError{}
+			if err := json.NewDecoder(resp.Body).Decode(gerr); err != nil || gerr.Message == "" {
+				return nil, fmt.Errorf("etcd: unexpected response status %s", resp.Status)
+			}
+			return nil, gerr
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// call is like post but re-authenticates once when the token has expired.
+func (c *Client) call(ctx context.Context, path string, v interface{}) (*http.Response, error) {
+	resp, err := c.post(ctx, path, v, true)
+	if err == errUnauthorized && c.basicAuth {
+		if err = c.authenticate(ctx); err != nil {
+			return nil, err
+		}
+		resp, err = c.post(ctx, path, v, true)
+	}
+	return resp, err
+}
+
+func (c *Client) rangeKeys(ctx context.Context, r rangeRequest) (*rangeResponse, error) {
+	resp, err := c.call(ctx, "/kv/range", r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	rr := &rangeResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(rr); err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// GetValues queries etcd for keys prefixed by prefix.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, key := range keys {
+		start, end := rangeFor(key)
+		rr, err := c.rangeKeys(context.Background(), rangeRequest{Key: start, RangeEnd: end})
+		if err != nil {
+			return vars, err
+		}
+		for _, kv := range rr.Kvs {
+			k := string(kv.Key)
+			if flatten.IsChild(k, key) {
+				vars[k] = string(kv.Value)
+			}
+		}
+	}
+	return vars, nil
+}
+
+// currentRevision returns the store revision seen by a range over prefix.
+func (c *Client) currentRevision(prefix string) (uint64, error) {
+	start, end := rangeFor(prefix)
+	rr, err := c.rangeKeys(context.Background(), rangeRequest{Key: start, RangeEnd: end, Limit: 1})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(rr.Header.Revision), nil
+}
+
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	// return the current revision to trigger a key retrieval from the store
+	// and to give the next watch a revision to resume from
+	if waitIndex == 0 {
+		return c.currentRevision(prefix)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelRoutine := make(chan bool)
+	defer close(cancelRoutine)
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-cancelRoutine:
+			return
+		}
+	}()
+
+	start, end := rangeFor(prefix)
+	create := map[string]interface{}{
+		"create_request": watchCreateRequest{
+			Key:           start,
+			RangeEnd:      end,
+			StartRevision: int64(waitIndex) + 1,
+		},
+	}
+	resp, err := c.call(ctx, "/watch", create)
+	if err != nil {
+		if ctx.Err() != nil {
+			return waitIndex, nil
+		}
+		return waitIndex, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var wr watchResponse
+		if err := dec.Decode(&wr); err != nil {
+			if ctx.Err() != nil {
+				return waitIndex, nil
+			}
+			if err == io.EOF {
+				err = errors.New("etcd: watch stream closed")
+			}
+			return waitIndex, err
+		}
+		if wr.Error != nil {
+			return waitIndex, wr.Error
+		}
+		if wr.Result.CompactRevision != 0 {
+			// The revision we wanted to resume from has been compacted away,
+			// start over with a full retrieval.
+			log.Warning("etcd revision %d has been compacted, resyncing", waitIndex)
+			return 0, nil
+		}
+		if wr.Result.Canceled {
+			return waitIndex, fmt.Errorf("etcd: watch canceled: %s", wr.Result.CancelReason)
+		}
+		for _, ev := range wr.Result.Events {
+			k := string(ev.Kv.Key)
+			for _, key := range keys {
+				if flatten.IsChild(k, key) {
+					return uint64(ev.Kv.ModRevision), nil
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package etcdv3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGateway is a minimal in-memory stand-in for the etcd v3 JSON gateway.
+type fakeGateway struct {
+	mu        sync.Mutex
+	revision  int64
+	kvs       map[string]keyValue
+	history   []watchEvent
+	compacted int64
+	token     string
+	password  string
+}
+
+func newFakeGateway() *fakeGateway {
+	return &fakeGateway{kvs: make(map[string]keyValue)}
+}
+
+func (g *fakeGateway) put(key, value string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.revision++
+	kv := keyValue{Key: []byte(key), Value: []byte(value), ModRevision: g.revision}
+	g.kvs[key] = kv
+	g.history = append(g.history, watchEvent{Kv: kv})
+}
+
+func inRange(k string, start, end []byte) bool {
+	if bytes.Equal(end, []byte{0}) {
+		return k >= string(start)
+	}
+	return k >= string(start) && k < string(end)
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && r.URL.Path != "/v3/auth/authenticate" && r.Header.Get("Authorization") != g.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/v3/auth/authenticate":
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["password"] != g.password {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(gatewayError{Code: 3, Message: "authentication failed"})
+			return
+		}
+		g.token = "token-" + strconv.FormatInt(g.revision, 10)
+		json.NewEncoder(w).Encode(map[string]string{"token": g.token})
+	case "/v3/kv/range":
+		var req rangeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := rangeResponse{Header: responseHeader{Revision: g.revision}}
+		for k, kv := range g.kvs {
+			if inRange(k, req.Key, req.RangeEnd) {
+				resp.Kvs = append(resp.Kvs, kv)
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "/v3/watch":
+		var req struct {
+			CreateRequest watchCreateRequest `json:"create_request"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var wr watchResponse
+		wr.Result.Header.Revision = g.revision
+		if req.CreateRequest.StartRevision <= g.compacted {
+			wr.Result.CompactRevision = g.compacted
+			json.NewEncoder(w).Encode(wr)
+			return
+		}
+		for _, ev := range g.history {
+			if ev.Kv.ModRevision >= req.CreateRequest.StartRevision &&
+				inRange(string(ev.Kv.Key), req.CreateRequest.Key, req.CreateRequest.RangeEnd) {
+				wr.Result.Events = append(wr.Result.Events, ev)
+			}
+		}
+		json.NewEncoder(w).Encode(wr)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestClient returns a client of g, authenticating as root when g has a
+// password.
+func newTestClient(t *testing.T, g *fakeGateway) (*Client, func()) {
+	ts := httptest.NewServer(g)
+	c, err := NewEtcdClient([]string{ts.URL}, "", "", "", g.password != "", "root", g.password)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return c, ts.Close
+}
+
+func TestGetValues(t *testing.T) {
+	g := newFakeGateway()
+	g.put("/app/database/host", "127.0.0.1")
+	g.put("/app/database/port", "3306")
+	g.put("/application/name", "other")
+	c, stop := newTestClient(t, g)
+	defer stop()
+
+	got, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/app/database/host": "127.0.0.1",
+		"/app/database/port": "3306",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+}
+
+func TestWatchPrefixResumesFromWaitIndex(t *testing.T) {
+	g := newFakeGateway()
+	g.put("/app/key", "1")
+	c, stop := newTestClient(t, g)
+	defer stop()
+
+	stopChan := make(chan bool)
+	index, err := c.WatchPrefix("/", []string{"/app"}, 0, stopChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 1 {
+		t.Fatalf("initial index = %d, want 1", index)
+	}
+
+	// Changes made between two watch calls must not be lost.
+	g.put("/other/key", "x")
+	g.put("/app/key", "2")
+	index, err = c.WatchPrefix("/", []string{"/app"}, index, stopChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 3 {
+		t.Errorf("index = %d, want 3", index)
+	}
+
+	g.compacted = 10
+	index, err = c.WatchPrefix("/", []string{"/app"}, index, stopChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 0 {
+		t.Errorf("index after compaction = %d, want 0", index)
+	}
+}
+
+func TestBasicAuthReauthenticates(t *testing.T) {
+	g := newFakeGateway()
+	g.password = "secret"
+	g.put("/app/key", "value")
+	c, stop := newTestClient(t, g)
+	defer stop()
+
+	// Simulate an expired token.
+	g.mu.Lock()
+	g.token = "rotated"
+	g.mu.Unlock()
+
+	got, err := c.GetValues([]string{"/app/key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["/app/key"] != "value" {
+		t.Errorf("GetValues() = %v", got)
+	}
+}
+
+// freeURL returns the URL of a local port nothing listens on.
+func freeURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return "http://" + l.Addr().String()
+}
+
+// startEtcd runs the etcd found in $PATH on a temporary data directory and
+// returns a client of its gateway, skipping the test when etcd is not
+// installed. The fake gateway above cannot tell whether the client speaks
+// the JSON of the real one.
+func startEtcd(t *testing.T) (*Client, func()) {
+	bin, err := exec.LookPath("etcd")
+	if err != nil {
+		t.Skip("etcd is not installed")
+	}
+	dir, err := ioutil.TempDir("", "confd-etcdv3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cmd := exec.Command(bin,
+		"--data-dir", dir,
+		"--listen-client-urls", clientURL,
+		"--advertise-client-urls", clientURL,
+		"--listen-peer-urls", peerURL,
+		"--initial-advertise-peer-urls", peerURL,
+		"--initial-cluster", "default="+peerURL,
+	)
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.RemoveAll(dir)
+	}
+
+	c, err := NewEtcdClient([]string{clientURL}, "", "", "", false, "", "")
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if _, err = c.GetValues([]string{"/"}); err == nil {
+			return c, stop
+		}
+		if time.Now().After(deadline) {
+			stop()
+			t.Fatalf("etcd did not start: %v", err)
+		}
+	}
+}
+
+// etcdCall posts a request to the gateway of etcd and returns the revision
+// of its answer.
+func etcdCall(t *testing.T, c *Client, path string, v interface{}) uint64 {
+	resp, err := c.call(context.Background(), path, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var answer struct {
+		Header responseHeader `json:"header"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		t.Fatal(err)
+	}
+	return uint64(answer.Header.Revision)
+}
+
+func etcdPut(t *testing.T, c *Client, key, value string) uint64 {
+	return etcdCall(t, c, "/kv/put", map[string][]byte{"key": []byte(key), "value": []byte(value)})
+}
+
+func TestEtcd(t *testing.T) {
+	c, stop := startEtcd(t)
+	defer stop()
+
+	etcdPut(t, c, "/app/database/host", "127.0.0.1")
+	etcdPut(t, c, "/application/name", "other")
+	created := etcdPut(t, c, "/app/database/port", "3306")
+
+	got, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/app/database/host": "127.0.0.1",
+		"/app/database/port": "3306",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+
+	stopChan := make(chan bool)
+	defer time.AfterFunc(5*time.Second, func() { close(stopChan) }).Stop()
+	index, err := c.WatchPrefix("/", []string{"/app"}, 0, stopChan)
+	if err != nil || index != created {
+		t.Fatalf("WatchPrefix() = %d, %v, want %d, nil", index, err, created)
+	}
+
+	// Changes made between two watch calls must not be lost, and changes
+	// to other keys below the prefix must not wake the watch.
+	etcdPut(t, c, "/application/name", "changed")
+	changed := etcdPut(t, c, "/app/database/host", "10.0.0.1")
+	index, err = c.WatchPrefix("/", []string{"/app"}, index, stopChan)
+	if err != nil || index != changed {
+		t.Fatalf("WatchPrefix() = %d, %v, want %d, nil", index, err, changed)
+	}
+
+	// A watch resuming from a compacted revision starts over.
+	latest := etcdPut(t, c, "/app/database/host", "10.0.0.2")
+	etcdCall(t, c, "/kv/compaction", map[string]uint64{"revision": latest})
+	index, err = c.WatchPrefix("/", []string{"/app"}, created, stopChan)
+	if err != nil || index != 0 {
+		t.Errorf("WatchPrefix() from a compacted revision = %d, %v, want 0, nil", index, err)
+	}
+}
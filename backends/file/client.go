@@ -0,0 +1,138 @@
+package file
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/changes"
+	"github.com/kelseyhightower/confd/backends/flatten"
+	"github.com/kelseyhightower/confd/log"
+)
+
+// Options are the file specific backend options.
+type Options struct {
+	// Files are the YAML, JSON or TOML files and directories to load.
+	Files []string `mapstructure:"file"`
+}
+
+// Client provides a shell for the file client
+type Client struct {
+	paths []string
+}
+
+func init() {
+	backends.Register("file", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return NewFileClient(opts.Files)
+	})
+}
+
+// NewFileClient returns a client reading the given YAML, JSON or TOML
+// files. A directory is searched recursively for files of those types.
+func NewFileClient(paths []string) (*Client, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no files configured for the file backend")
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return nil, err
+		}
+	}
+	return &Client{paths}, nil
+}
+
+// files expands the configured paths into the list of documents to load.
+func (c *Client) files() ([]string, error) {
+	files := make([]string, 0, len(c.paths))
+	for _, p := range c.paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && flatten.Supported(path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// GetValues flattens the configured files and returns the keys below keys.
+// Files are loaded in order, so later files override earlier ones.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]string)
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		val, err := flatten.Decode(f, data)
+		if err != nil {
+			return nil, err
+		}
+		flatten.Walk("/", val, all)
+	}
+
+	vars := make(map[string]string)
+	for k, v := range all {
+		for _, key := range keys {
+			if flatten.IsChild(k, key) {
+				vars[k] = v
+				break
+			}
+		}
+	}
+	log.Debug("Key Map: %#v", vars)
+	return vars, nil
+}
+
+// index returns a checksum of the contents of the configured files.
+func (c *Client) index() (uint64, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+	contents := make(map[string]string, len(files))
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return 0, err
+		}
+		contents[f] = string(data)
+	}
+	return changes.Checksum(contents), nil
+}
+
+// WatchPrefix blocks until the contents of the configured files differ
+// from the ones of waitIndex, and returns their checksum as index. Files
+// written while the templates were rendered are noticed right away.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	// return something > 0 to trigger a key retrieval from the store
+	if waitIndex == 0 {
+		return c.index()
+	}
+	return c.watch(waitIndex, stopChan)
+}
@@ -0,0 +1,115 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "confd-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defaults := filepath.Join(dir, "defaults.yaml")
+	overrides := filepath.Join(dir, "overrides.json")
+	writeFile(t, defaults, "database:\n  host: 127.0.0.1\n  port: 3306\napp:\n  name: confd\n")
+	writeFile(t, overrides, `{"database": {"host": "db.example.com"}}`)
+
+	c, err := NewFileClient([]string{defaults, overrides})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetValues([]string{"/database"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/database/host": "db.example.com",
+		"/database/port": "3306",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "confd-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.toml")
+	writeFile(t, path, "key = \"one\"\n")
+
+	c, err := NewFileClient([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopChan := make(chan bool)
+	first, err := c.WatchPrefix("/", []string{"/key"}, 0, stopChan)
+	if err != nil || first == 0 {
+		t.Fatalf("WatchPrefix() = %d, %v, want a non-zero index", first, err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		writeFile(t, filepath.Join(dir, "unrelated.txt"), "ignored")
+		writeFile(t, path, "key = \"two\"\n")
+	}()
+	index, err := c.WatchPrefix("/", []string{"/key"}, first, stopChan)
+	if err != nil || index == first {
+		t.Fatalf("WatchPrefix() = %d, %v, want an index other than %d", index, err, first)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(stopChan)
+	}()
+	next, err := c.WatchPrefix("/", []string{"/key"}, index, stopChan)
+	if err != nil || next != index {
+		t.Fatalf("WatchPrefix() after stop = %d, %v, want %d, nil", next, err, index)
+	}
+}
+
+func TestWatchPrefixWriteBetweenCalls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "confd-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, "key: one\n")
+
+	c, err := NewFileClient([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopChan := make(chan bool)
+	defer time.AfterFunc(5*time.Second, func() { close(stopChan) }).Stop()
+	first, err := c.WatchPrefix("/", []string{"/key"}, 0, stopChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is written while the templates are rendered, before the
+	// next call.
+	writeFile(t, path, "key: two\n")
+	index, err := c.WatchPrefix("/", []string{"/key"}, first, stopChan)
+	if err != nil || index == first {
+		t.Fatalf("WatchPrefix() = %d, %v, want an index other than %d", index, err, first)
+	}
+}
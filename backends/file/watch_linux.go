@@ -0,0 +1,144 @@
+// +build linux
+
+package file
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MODIFY | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF
+
+// watch blocks until the contents of the configured files differ from the
+// ones of waitIndex, and returns their checksum. They are checked again
+// whenever a file below one of the configured paths is written, created,
+// removed or renamed. It returns waitIndex if stopChan fired first.
+//
+// Files are watched through their parent directory so that editors and
+// tools which replace a file by renaming a new one over it are noticed.
+// They are read once watched, so that no write is missed in between.
+func (c *Client) watch(waitIndex uint64, stopChan chan bool) (uint64, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return waitIndex, err
+	}
+	// A non-blocking descriptor is handled by the runtime poller, so closing
+	// the file interrupts a pending Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+
+	// names maps a watch descriptor to the file names we care about in that
+	// directory. A nil entry means every name does.
+	names := make(map[int32]map[string]bool)
+	addWatch := func(dir, name string) error {
+		wd, err := unix.InotifyAddWatch(fd, dir, watchMask)
+		if err != nil {
+			return err
+		}
+		w := int32(wd)
+		if name == "" {
+			names[w] = nil
+			return nil
+		}
+		if filter, ok := names[w]; !ok {
+			names[w] = map[string]bool{name: true}
+		} else if filter != nil {
+			filter[name] = true
+		}
+		return nil
+	}
+
+	for _, p := range c.paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return waitIndex, err
+		}
+		if !fi.IsDir() {
+			if err := addWatch(filepath.Dir(p), filepath.Base(p)); err != nil {
+				return waitIndex, err
+			}
+			continue
+		}
+		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return addWatch(path, "")
+			}
+			return nil
+		})
+		if err != nil {
+			return waitIndex, err
+		}
+	}
+
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stopChan:
+			close(stopped)
+			f.Close()
+		case <-done:
+		}
+	}()
+
+	buf := make([]byte, (unix.SizeofInotifyEvent+unix.PathMax)*16)
+	for {
+		index, err := c.index()
+		if err != nil || index != waitIndex {
+			return index, err
+		}
+		lost, err := readEvents(f, buf, names, stopped)
+		if err != nil {
+			return waitIndex, err
+		}
+		select {
+		case <-stopped:
+			return waitIndex, nil
+		default:
+		}
+		if lost {
+			// The next call watches the paths again.
+			return c.index()
+		}
+	}
+}
+
+// readEvents reads the events of f until one concerns a watched file, or
+// the watches may have been lost, which it reports. It returns once
+// stopped is closed.
+func readEvents(f *os.File, buf []byte, names map[int32]map[string]bool, stopped chan struct{}) (bool, error) {
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			select {
+			case <-stopped:
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + unix.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[start:start+int(ev.Len)], "\x00"))
+			offset = start + int(ev.Len)
+
+			if ev.Mask&(unix.IN_Q_OVERFLOW|unix.IN_IGNORED|unix.IN_DELETE_SELF) != 0 {
+				return true, nil
+			}
+			filter, ok := names[ev.Wd]
+			if ok && (filter == nil || filter[name]) {
+				return false, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// +build !linux
+
+package file
+
+import "time"
+
+// pollInterval is how often the files are checked for changes on
+// platforms without inotify.
+const pollInterval = time.Second
+
+// watch polls the configured files until their contents differ from the
+// ones of waitIndex, and returns their checksum. It returns waitIndex if
+// stopChan fired first.
+func (c *Client) watch(waitIndex uint64, stopChan chan bool) (uint64, error) {
+	for {
+		index, err := c.index()
+		if err != nil || index != waitIndex {
+			return index, err
+		}
+		select {
+		case <-stopChan:
+			return waitIndex, nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package flatten turns structured documents into the flat /a/b/c
+// key/value pairs that confd templates consume.
+package flatten
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kelseyhightower/confd/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Supported reports whether Decode knows how to parse the named file.
+func Supported(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml", ".toml":
+		return true
+	}
+	return false
+}
+
+// Decode parses data as JSON, YAML or TOML depending on the extension of
+// name.
+func Decode(name string, data []byte) (interface{}, error) {
+	var val interface{}
+	var err error
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		err = json.Unmarshal(data, &val)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &val)
+	case ".toml":
+		m := make(map[string]interface{})
+		_, err = toml.Decode(string(data), &m)
+		val = m
+	default:
+		return nil, fmt.Errorf("unsupported file format: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %s", name, err)
+	}
+	return val, nil
+}
+
+// Walk recursively descends val, storing every leaf value in vars under
+// a key built from root and the path to the leaf.
+//
+// Maps become nested keys and arrays become indexed keys. An array item
+// that is a map with a "name" field is keyed by that name instead of its
+// index.
+func Walk(root string, val interface{}, vars map[string]string) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			Walk(join(root, k), item, vars)
+		}
+	case map[interface{}]interface{}:
+		for k, item := range v {
+			Walk(join(root, fmt.Sprint(k)), item, vars)
+		}
+	case []map[string]interface{}:
+		for i, item := range v {
+			Walk(join(root, index(i, item)), item, vars)
+		}
+	case []interface{}:
+		for i, item := range v {
+			Walk(join(root, index(i, item)), item, vars)
+		}
+	case bool:
+		vars[root] = strconv.FormatBool(v)
+	case string:
+		vars[root] = v
+	case float64:
+		vars[root] = strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		vars[root] = strconv.Itoa(v)
+	case int64:
+		vars[root] = strconv.FormatInt(v, 10)
+	case uint64:
+		vars[root] = strconv.FormatUint(v, 10)
+	case time.Time:
+		vars[root] = v.Format(time.RFC3339)
+	case nil:
+		vars[root] = "null"
+	default:
+		log.Error("Unknown type: %s", reflect.TypeOf(val).String())
+	}
+}
+
+// IsChild reports whether k is the key itself or lives below it. An empty
+// key, or "/", holds every key.
+func IsChild(k, key string) bool {
+	key = strings.TrimSuffix(key, "/")
+	return key == "" || k == key || strings.HasPrefix(k, key+"/")
+}
+
+func join(root, key string) string {
+	return strings.TrimSuffix(root, "/") + "/" + key
+}
+
+func index(i int, item interface{}) string {
+	var name interface{}
+	switch m := item.(type) {
+	case map[string]interface{}:
+		name = m["name"]
+	case map[interface{}]interface{}:
+		name = m["name"]
+	}
+	if s, ok := name.(string); ok {
+		return s
+	}
+	return strconv.Itoa(i)
+}
@@ -0,0 +1,86 @@
+package flatten
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	doc := `
+database:
+  host: 127.0.0.1
+  port: 3306
+  enabled: true
+  replicas:
+    - 10.0.0.1
+    - 10.0.0.2
+upstream:
+  - name: app1
+    addr: 10.0.1.10:8080
+empty: null
+`
+	val, err := Decode("config.yaml", []byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	Walk("/", val, got)
+	want := map[string]string{
+		"/database/host":       "127.0.0.1",
+		"/database/port":       "3306",
+		"/database/enabled":    "true",
+		"/database/replicas/0": "10.0.0.1",
+		"/database/replicas/1": "10.0.0.2",
+		"/upstream/app1/name":  "app1",
+		"/upstream/app1/addr":  "10.0.1.10:8080",
+		"/empty":               "null",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"a.json", `{"db": {"port": 5432}}`},
+		{"a.yml", "db:\n  port: 5432\n"},
+		{"a.toml", "[db]\nport = 5432\n"},
+	}
+	for _, tt := range tests {
+		val, err := Decode(tt.name, []byte(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := make(map[string]string)
+		Walk("/app", val, got)
+		if got["/app/db/port"] != "5432" {
+			t.Errorf("%s: Walk() = %v", tt.name, got)
+		}
+	}
+	if _, err := Decode("a.ini", nil); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+}
+
+func TestIsChild(t *testing.T) {
+	tests := []struct {
+		k, key string
+		want   bool
+	}{
+		{"/app/db", "/app/db", true},
+		{"/app/db/host", "/app/db", true},
+		{"/app/db/host", "/app/db/", true},
+		{"/app/dbx", "/app/db", false},
+		{"/app", "/app/db", false},
+		{"/app/db", "/", true},
+		{"/app/db", "", true},
+	}
+	for _, tt := range tests {
+		if got := IsChild(tt.k, tt.key); got != tt.want {
+			t.Errorf("IsChild(%q, %q) = %v, want %v", tt.k, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/changes"
+	"github.com/kelseyhightower/confd/backends/flatten"
+	"github.com/kelseyhightower/confd/log"
+)
+
+const (
+	// pollInterval is how often the ref is resolved while watching.
+	pollInterval = time.Second
+)
+
+// CommitKey and RefKey are set on every GetValues result so templates can
+// report which commit they were rendered from.
+const (
+	CommitKey = "/_git/commit"
+	RefKey    = "/_git/ref"
+)
+
+// Options are the git specific backend options.
+type Options struct {
+	// Ref is the branch, tag or commit keys are read from, HEAD by default.
+	Ref string `mapstructure:"ref"`
+	// FetchInterval is the delay between two `git fetch` runs while
+	// watching. Fetching is disabled when it is 0.
+	FetchInterval time.Duration `mapstructure:"fetch_interval"`
+}
+
+// Client reads keys from the files of a local git clone at a given ref.
+// File paths become keys and file contents become values. JSON, YAML and
+// TOML files are flattened below their path without the extension.
+type Client struct {
+	repo          string
+	ref           string
+	fetchInterval time.Duration
+
+	mu        sync.Mutex
+	lastFetch time.Time
+	cacheSHA  string
+	cache     map[string]string
+	// commits holds the commit the ref pointed to at the indexes returned
+	// by WatchPrefix.
+	commits *changes.States
+}
+
+func init() {
+	backends.Register("git", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return NewGitClient(config.BackendNodes[0], opts)
+	})
+}
+
+// NewGitClient returns a client for the clone at repo. If the fetch
+// interval is positive, watches run `git fetch` at most that often so that
+// remote tracking refs move.
+func NewGitClient(repo string, opts Options) (*Client, error) {
+	if repo == "" {
+		return nil, errors.New("no git repository configured")
+	}
+	if opts.Ref == "" {
+		opts.Ref = "HEAD"
+	}
+	c := &Client{
+		repo:          repo,
+		ref:           opts.Ref,
+		fetchInterval: opts.FetchInterval,
+		commits:       changes.NewStates(),
+	}
+	sha, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Using git ref %s at %s", opts.Ref, sha)
+	return c, nil
+}
+
+func (c *Client) git(stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = c.repo
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %s: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// resolve returns the commit the configured ref points to.
+func (c *Client) resolve() (string, error) {
+	out, err := c.git(nil, "rev-parse", "--verify", "--quiet", c.ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve git ref %s: %s", c.ref, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// sequence returns the number of commits reachable from sha.
+func (c *Client) sequence(sha string) (uint64, error) {
+	out, err := c.git(nil, "rev-list", "--count", sha)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+}
+
+// index returns the index of commit sha, the number of commits reachable
+// from it, and remembers the commit of the index.
+func (c *Client) index(sha string) (uint64, error) {
+	seq, err := c.sequence(sha)
+	if err != nil {
+		return 0, err
+	}
+	// The ref may have been moved to another commit at the same depth, it
+	// then gets the next free index.
+	return c.commits.Put(seq, sha), nil
+}
+
+// fetch runs git fetch if the fetch interval has elapsed.
+func (c *Client) fetch() error {
+	if c.fetchInterval <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	due := time.Since(c.lastFetch) >= c.fetchInterval
+	if due {
+		c.lastFetch = time.Now()
+	}
+	c.mu.Unlock()
+	if !due {
+		return nil
+	}
+	log.Debug("Fetching git repository %s", c.repo)
+	_, err := c.git(nil, "fetch", "--quiet")
+	return err
+}
+
+// tree reads and flattens every file of commit sha.
+func (c *Client) tree(sha string) (map[string]string, error) {
+	out, err := c.git(nil, "ls-tree", "-r", "-z", "--full-tree", sha)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths, blobs []string
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> TAB <path>
+		tab := strings.Index(entry, "\t")
+		if tab < 0 {
+			continue
+		}
+		fields := strings.Fields(entry[:tab])
+		if len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		paths = append(paths, entry[tab+1:])
+		blobs = append(blobs, fields[2])
+	}
+
+	vars := make(map[string]string)
+	if len(blobs) == 0 {
+		return vars, nil
+	}
+	out, err = c.git(strings.NewReader(strings.Join(blobs, "\n")+"\n"), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(bytes.NewReader(out))
+	for _, p := range paths {
+		// <object> SP <type> SP <size> LF <contents> LF
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected git cat-file output %q", header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+1)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		data = data[:size]
+
+		key := "/" + p
+		if !flatten.Supported(p) {
+			vars[key] = string(data)
+			continue
+		}
+		val, err := flatten.Decode(p, data)
+		if err != nil {
+			return nil, err
+		}
+		flatten.Walk(strings.TrimSuffix(key, path.Ext(key)), val, vars)
+	}
+	return vars, nil
+}
+
+// GetValues returns the keys below keys at the current commit of the ref,
+// along with the commit SHA under CommitKey and the ref under RefKey.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	sha, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	all := c.cache
+	if c.cacheSHA != sha {
+		all = nil
+	}
+	c.mu.Unlock()
+
+	if all == nil {
+		if all, err = c.tree(sha); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.cacheSHA, c.cache = sha, all
+		c.mu.Unlock()
+	}
+
+	vars := make(map[string]string)
+	for k, v := range all {
+		for _, key := range keys {
+			if flatten.IsChild(k, key) {
+				vars[k] = v
+				break
+			}
+		}
+	}
+	vars[CommitKey] = sha
+	vars[RefKey] = c.ref
+	return vars, nil
+}
+
+// WatchPrefix waits for the ref to point to another commit than the one
+// of waitIndex, fetching first if a fetch interval is set, and returns the
+// number of commits reachable from it.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	last, ok := c.commits.Get(waitIndex)
+
+	for {
+		if err := c.fetch(); err != nil {
+			log.Error("Failed to fetch git repository %s: %s", c.repo, err.Error())
+		}
+		sha, err := c.resolve()
+		if err != nil {
+			return waitIndex, err
+		}
+
+		// return something > 0 to trigger a key retrieval from the store.
+		// Without the commit of waitIndex, the current one is returned.
+		if waitIndex == 0 || !ok || sha != last {
+			index, err := c.index(sha)
+			if err != nil {
+				return waitIndex, err
+			}
+			return index, nil
+		}
+
+		select {
+		case <-stopChan:
+			return waitIndex, nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
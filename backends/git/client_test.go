@@ -0,0 +1,131 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func run(t *testing.T, dir string, args ...string) {
+	args = append([]string{"-c", "user.name=confd", "-c", "user.email=confd@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+}
+
+func commit(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run(t, dir, "add", "-A")
+	run(t, dir, "commit", "-q", "-m", "update")
+}
+
+func newTestRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "confd-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, dir, "init", "-q")
+	commit(t, dir, map[string]string{
+		"app/name":          "confd",
+		"app/database.yaml": "host: 127.0.0.1\nport: 3306\n",
+		"other/key":         "value",
+	})
+	return dir
+}
+
+func TestGetValues(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewGitClient(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha, _ := c.resolve()
+	want := map[string]string{
+		"/app/name":          "confd",
+		"/app/database/host": "127.0.0.1",
+		"/app/database/port": "3306",
+		CommitKey:            sha,
+		RefKey:               "HEAD",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewGitClient(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopChan := make(chan bool)
+	index, err := c.WatchPrefix("/", []string{"/app"}, 0, stopChan)
+	if err != nil || index != 1 {
+		t.Fatalf("WatchPrefix() = %d, %v, want 1, nil", index, err)
+	}
+
+	commit(t, dir, map[string]string{"app/name": "confd2"})
+	index, err = c.WatchPrefix("/", []string{"/app"}, index, stopChan)
+	if err != nil || index != 2 {
+		t.Fatalf("WatchPrefix() = %d, %v, want 2, nil", index, err)
+	}
+	got, err := c.GetValues([]string{"/app/name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["/app/name"] != "confd2" {
+		t.Errorf("GetValues() = %v", got)
+	}
+}
+
+func TestWatchPrefixSharedByResources(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewGitClient(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopChan := make(chan bool)
+	defer time.AfterFunc(5*time.Second, func() { close(stopChan) }).Stop()
+	keys := []string{"/app"}
+	first, err := c.WatchPrefix("/", keys, 0, stopChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two resources watch the same prefix, the second one must see the
+	// commit the first one already returned.
+	commit(t, dir, map[string]string{"app/name": "confd2"})
+	for _, resource := range []string{"a", "b"} {
+		index, err := c.WatchPrefix("/", keys, first, stopChan)
+		if err != nil || index != 2 {
+			t.Fatalf("WatchPrefix() of resource %s = %d, %v, want 2, nil", resource, index, err)
+		}
+	}
+}
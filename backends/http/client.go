@@ -0,0 +1,284 @@
+package http
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/changes"
+	"github.com/kelseyhightower/confd/backends/flatten"
+	"github.com/kelseyhightower/confd/log"
+)
+
+const (
+	// pollInterval is the delay between two conditional requests when the
+	// server does not support long polling.
+	pollInterval = 5 * time.Second
+)
+
+// Options are the http specific backend options.
+type Options struct {
+	// Headers are sent with every request, as "Name: value".
+	Headers []string `mapstructure:"headers"`
+	// LongPollParam is the query parameter watches pass the last seen ETag
+	// in. The server is expected to hold the request until it changes.
+	LongPollParam string `mapstructure:"long_poll_param"`
+}
+
+// Client fetches JSON documents over HTTP and flattens them into keys.
+type Client struct {
+	urls          []string
+	headers       http.Header
+	longPollParam string
+	httpClient    *http.Client
+
+	mu      sync.Mutex
+	current int
+	// etags holds the ETag of the indexes returned by WatchPrefix.
+	etags *changes.States
+}
+
+func init() {
+	backends.Register("http", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return NewHTTPClient(config.BackendNodes, config.Scheme, config.ClientCert, config.ClientKey, config.ClientCaKeys, config.AuthToken, opts)
+	})
+}
+
+// NewHTTPClient returns a client for the JSON service at the given base
+// URLs. Every request carries the headers of opts and, if set, the bearer
+// token.
+func NewHTTPClient(nodes []string, scheme, cert, key, caCert, authToken string, opts Options) (*Client, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("no URL configured for the http backend")
+	}
+
+	tlsConfig := &tls.Config{}
+	if cert != "" && key != "" {
+		clientCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+		tlsConfig.BuildNameToCertificate()
+	}
+	if caCert != "" {
+		ca, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(ca)
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	h := make(http.Header)
+	for _, header := range opts.Headers {
+		i := strings.Index(header, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", header)
+		}
+		h.Add(strings.TrimSpace(header[:i]), strings.TrimSpace(header[i+1:]))
+	}
+	if authToken != "" {
+		h.Set("Authorization", "Bearer "+authToken)
+	}
+	h.Set("Accept", "application/json")
+
+	urls := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if !strings.Contains(node, "://") {
+			node = scheme + "://" + node
+		}
+		urls = append(urls, strings.TrimRight(node, "/"))
+	}
+
+	return &Client{
+		urls:          urls,
+		headers:       h,
+		longPollParam: opts.LongPollParam,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		etags: changes.NewStates(),
+	}, nil
+}
+
+// response is a fetched document together with its version tag.
+type response struct {
+	status int
+	etag   string
+	body   []byte
+}
+
+// fetch requests path from the first URL that answers, starting with the
+// one that answered last time.
+func (c *Client) fetch(ctx context.Context, path string, query url.Values, etag string) (*response, error) {
+	c.mu.Lock()
+	start := c.current
+	c.mu.Unlock()
+
+	var lastErr error
+	for i := 0; i < len(c.urls); i++ {
+		idx := (start + i) % len(c.urls)
+		u := c.urls[idx] + path
+		if len(query) > 0 {
+			u += "?" + query.Encode()
+		}
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range c.headers {
+			req.Header[k] = v
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		resp, err := c.httpClient.Do(req.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Debug("http backend %s failed: %v", c.urls[idx], err)
+			lastErr = err
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c.mu.Lock()
+		c.current = idx
+		c.mu.Unlock()
+
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusNotModified, http.StatusNotFound:
+		default:
+			return nil, fmt.Errorf("http backend: unexpected response status %s from %s", resp.Status, u)
+		}
+
+		r := &response{status: resp.StatusCode, etag: resp.Header.Get("ETag"), body: body}
+		if r.etag == "" && r.status != http.StatusNotModified {
+			// Fall back to a content hash for servers that do not send ETags.
+			sum := sha1.Sum(body)
+			r.etag = `"` + hex.EncodeToString(sum[:]) + `"`
+		}
+		return r, nil
+	}
+	return nil, lastErr
+}
+
+// GetValues fetches every key as a JSON document and flattens it.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, key := range keys {
+		r, err := c.fetch(context.Background(), key, nil, "")
+		if err != nil {
+			return vars, err
+		}
+		if r.status == http.StatusNotFound {
+			continue
+		}
+
+		var jsonResponse interface{}
+		if err = json.Unmarshal(r.body, &jsonResponse); err != nil {
+			return vars, err
+		}
+		flatten.Walk(key, jsonResponse, vars)
+	}
+	return vars, nil
+}
+
+// index returns the index of the ETag of prefix, a hash of both, and
+// remembers the ETag of the index.
+func (c *Client) index(prefix, etag string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(prefix))
+	h.Write([]byte{0})
+	h.Write([]byte(etag))
+	return c.etags.Put(h.Sum64(), etag)
+}
+
+// WatchPrefix re-requests prefix with the ETag of waitIndex in
+// If-None-Match until it changes, either by polling or by long polling when
+// a long-poll parameter is set.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelRoutine := make(chan bool)
+	defer close(cancelRoutine)
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-cancelRoutine:
+			return
+		}
+	}()
+
+	state, ok := c.etags.Get(waitIndex)
+	etag, _ := state.(string)
+
+	// return something > 0 to trigger a key retrieval from the store.
+	// Without the ETag of waitIndex, the current one is returned.
+	if waitIndex == 0 || !ok {
+		r, err := c.fetch(ctx, prefix, nil, "")
+		if err != nil {
+			return waitIndex, err
+		}
+		return c.index(prefix, r.etag), nil
+	}
+
+	for {
+		var query url.Values
+		if c.longPollParam != "" {
+			query = url.Values{c.longPollParam: []string{strings.Trim(etag, `"`)}}
+		}
+		start := time.Now()
+		r, err := c.fetch(ctx, prefix, query, etag)
+		if err != nil {
+			if ctx.Err() != nil {
+				return waitIndex, nil
+			}
+			return waitIndex, err
+		}
+		if r.status != http.StatusNotModified && r.etag != etag {
+			return c.index(prefix, r.etag), nil
+		}
+		delay := pollInterval
+		if c.longPollParam != "" {
+			// The server already held the request, only back off if it
+			// answered right away.
+			delay = time.Second - time.Since(start)
+		}
+		select {
+		case <-ctx.Done():
+			return waitIndex, nil
+		case <-time.After(delay):
+		}
+	}
+}
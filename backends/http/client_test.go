@@ -0,0 +1,147 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDocument serves a version document at every path, holding the
+// requests with the current ETag until the version changes.
+type fakeDocument struct {
+	mu      sync.Mutex
+	version int
+	// polls holds the long poll parameter of the requests with an ETag.
+	polls []string
+}
+
+func (f *fakeDocument) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	if r.Header.Get("If-None-Match") != "" {
+		f.polls = append(f.polls, r.URL.Query().Get("wait"))
+	}
+	f.mu.Unlock()
+	for {
+		f.mu.Lock()
+		etag := fmt.Sprintf(`"v%d"`, f.version)
+		body := fmt.Sprintf(`{"version": %d}`, f.version)
+		f.mu.Unlock()
+		if r.Header.Get("If-None-Match") != etag {
+			w.Header().Set("ETag", etag)
+			fmt.Fprint(w, body)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (f *fakeDocument) bump() {
+	f.mu.Lock()
+	f.version++
+	f.mu.Unlock()
+}
+
+// newTestClient returns a client of h, sending the bearer token secret.
+func newTestClient(t *testing.T, h http.Handler, opts Options) (*Client, func()) {
+	ts := httptest.NewServer(h)
+	c, err := NewHTTPClient([]string{ts.URL}, "http", "", "", "", "secret", opts)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return c, ts.Close
+}
+
+func TestGetValues(t *testing.T) {
+	c, stop := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Env") != "prod" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/database":
+			fmt.Fprint(w, `{"host": "127.0.0.1", "port": 3306, "replicas": ["a", "b"]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}), Options{Headers: []string{"X-Env: prod"}})
+	defer stop()
+
+	got, err := c.GetValues([]string{"/database", "/missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/database/host":       "127.0.0.1",
+		"/database/port":       "3306",
+		"/database/replicas/0": "a",
+		"/database/replicas/1": "b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+}
+
+func TestWatchPrefixLongPoll(t *testing.T) {
+	f := &fakeDocument{version: 1}
+	c, stop := newTestClient(t, f, Options{LongPollParam: "wait"})
+	defer stop()
+
+	stopChan := make(chan bool)
+	defer time.AfterFunc(5*time.Second, func() { close(stopChan) }).Stop()
+	first, err := c.WatchPrefix("/app", []string{"/app"}, 0, stopChan)
+	if err != nil || first == 0 {
+		t.Fatalf("WatchPrefix() = %d, %v, want a non-zero index", first, err)
+	}
+
+	// The change arrives while the request is held.
+	time.AfterFunc(50*time.Millisecond, f.bump)
+	index, err := c.WatchPrefix("/app", []string{"/app"}, first, stopChan)
+	if err != nil || index == first {
+		t.Fatalf("WatchPrefix() = %d, %v, want an index other than %d", index, err, first)
+	}
+	if etag, _ := c.etags.Get(index); etag != `"v2"` {
+		t.Errorf("etag = %v, want \"v2\"", etag)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if want := []string{"v1"}; !reflect.DeepEqual(f.polls, want) {
+		t.Errorf("long poll parameters = %v, want %v", f.polls, want)
+	}
+}
+
+func TestWatchPrefixSharedByResources(t *testing.T) {
+	f := &fakeDocument{version: 1}
+	c, stop := newTestClient(t, f, Options{LongPollParam: "wait"})
+	defer stop()
+
+	stopChan := make(chan bool)
+	defer time.AfterFunc(5*time.Second, func() { close(stopChan) }).Stop()
+	first, err := c.WatchPrefix("/app", []string{"/app"}, 0, stopChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two resources watch the same prefix, the second one must see the
+	// change the first one already returned.
+	f.bump()
+	var indexes []uint64
+	for _, resource := range []string{"a", "b"} {
+		index, err := c.WatchPrefix("/app", []string{"/app"}, first, stopChan)
+		if err != nil || index == first {
+			t.Fatalf("WatchPrefix() of resource %s = %d, %v, want an index other than %d", resource, index, err, first)
+		}
+		indexes = append(indexes, index)
+	}
+	if indexes[0] != indexes[1] {
+		t.Errorf("WatchPrefix() returned %d and %d for the same document", indexes[0], indexes[1])
+	}
+}
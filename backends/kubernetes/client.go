@@ -0,0 +1,400 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/flatten"
+	"github.com/kelseyhightower/confd/log"
+)
+
+// Options are the kubernetes specific backend options.
+type Options struct {
+	// Kubeconfig is the kubeconfig file to use. It defaults to $KUBECONFIG,
+	// then to the service account of the pod.
+	Kubeconfig string `mapstructure:"kubeconfig"`
+}
+
+// Client maps /<namespace>/<name>/<key> to the data of ConfigMaps and
+// Secrets.
+type Client struct {
+	config     *restConfig
+	httpClient *http.Client
+}
+
+func init() {
+	backends.Register("kubernetes", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return NewKubernetesClient(config.BackendNodes, opts)
+	})
+}
+
+// NewKubernetesClient returns a client for the API server described by the
+// kubeconfig file, or by the pod's service account if there is none. A
+// backend node, if given, overrides the API server address.
+func NewKubernetesClient(nodes []string, opts Options) (*Client, error) {
+	kubeconfig := opts.Kubeconfig
+	if kubeconfig == "" {
+		kubeconfig = os.Getenv("KUBECONFIG")
+	}
+
+	var rc *restConfig
+	var err error
+	if kubeconfig != "" {
+		log.Info("Using kubeconfig %s", kubeconfig)
+		rc, err = kubeconfigConfig(kubeconfig)
+	} else {
+		log.Info("Using in-cluster kubernetes configuration")
+		rc, err = inClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) > 0 {
+		rc.host = strings.TrimRight(nodes[0], "/")
+	}
+	log.Info("Using kubernetes API server %s", rc.host)
+
+	return &Client{
+		config: rc,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: rc.tlsConfig,
+			},
+		},
+	}, nil
+}
+
+type objectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// object is the part of a ConfigMap or Secret that confd cares about.
+// Secret data is base64 encoded, ConfigMap data is not.
+type object struct {
+	Metadata   objectMeta        `json:"metadata"`
+	Data       map[string]string `json:"data"`
+	BinaryData map[string]string `json:"binaryData"`
+	Code       int               `json:"code"`
+	Message    string            `json:"message"`
+}
+
+type objectList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []object `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string `json:"type"`
+	Object object `json:"object"`
+}
+
+// kinds are the resources exposed as keys, in ascending precedence.
+var kinds = []string{"configmaps", "secrets"}
+
+// errNotFound is returned when the requested object does not exist.
+var errNotFound = errors.New("kubernetes: not found")
+
+func (c *Client) request(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := c.config.host + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.config.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.token)
+	} else if c.config.username != "" {
+		req.SetBasicAuth(c.config.username, c.config.password)
+	}
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status object
+		if err := json.NewDecoder(resp.Body).Decode(&status); err == nil && status.Message != "" {
+			return nil, fmt.Errorf("kubernetes: %s", status.Message)
+		}
+		return nil, fmt.Errorf("kubernetes: unexpected response status %s from %s", resp.Status, path)
+	}
+	return resp, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.request(ctx, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// resourcePath returns the API path of kind, optionally restricted to a
+// namespace and a single object.
+func resourcePath(kind, namespace, name string) string {
+	p := "/api/v1"
+	if namespace != "" {
+		p += "/namespaces/" + namespace
+	}
+	p += "/" + kind
+	if name != "" {
+		p += "/" + name
+	}
+	return p
+}
+
+// splitKey splits /<namespace>/<name>/<key> into its parts.
+func splitKey(key string) (namespace, name, dataKey string) {
+	parts := strings.SplitN(strings.Trim(key, "/"), "/", 3)
+	switch len(parts) {
+	case 3:
+		dataKey = parts[2]
+		fallthrough
+	case 2:
+		name = parts[1]
+		fallthrough
+	case 1:
+		namespace = parts[0]
+	}
+	return
+}
+
+// values returns the decoded key/value pairs of o.
+func values(kind string, o object) (map[string]string, error) {
+	vars := make(map[string]string, len(o.Data)+len(o.BinaryData))
+	for k, v := range o.BinaryData {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode %s/%s key %s: %s", o.Metadata.Namespace, o.Metadata.Name, k, err)
+		}
+		vars[k] = string(b)
+	}
+	for k, v := range o.Data {
+		if kind == "secrets" {
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, fmt.Errorf("cannot decode %s/%s key %s: %s", o.Metadata.Namespace, o.Metadata.Name, k, err)
+			}
+			v = string(b)
+		}
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+// GetValues reads the ConfigMaps and Secrets selected by keys. A Secret
+// wins over a ConfigMap of the same name.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	ctx := context.Background()
+	for _, key := range keys {
+		namespace, name, _ := splitKey(key)
+		for _, kind := range kinds {
+			var objects []object
+			if name == "" {
+				var list objectList
+				if err := c.get(ctx, resourcePath(kind, namespace, ""), &list); err != nil {
+					return vars, err
+				}
+				objects = list.Items
+			} else {
+				var o object
+				err := c.get(ctx, resourcePath(kind, namespace, name), &o)
+				if err == errNotFound {
+					continue
+				}
+				if err != nil {
+					return vars, err
+				}
+				objects = []object{o}
+			}
+
+			for _, o := range objects {
+				data, err := values(kind, o)
+				if err != nil {
+					return vars, err
+				}
+				for k, v := range data {
+					fullKey := "/" + o.Metadata.Namespace + "/" + o.Metadata.Name + "/" + k
+					if flatten.IsChild(fullKey, key) {
+						vars[fullKey] = v
+					}
+				}
+			}
+		}
+	}
+	return vars, nil
+}
+
+// watchNamespace returns the namespace shared by all keys, or "" if the
+// keys span several namespaces.
+func watchNamespace(keys []string) string {
+	namespace := ""
+	for i, key := range keys {
+		ns, _, _ := splitKey(key)
+		if ns == "" || (i > 0 && ns != namespace) {
+			return ""
+		}
+		namespace = ns
+	}
+	return namespace
+}
+
+// affects reports whether a change to o can change the value of a key.
+func affects(o object, keys []string) bool {
+	objectKey := "/" + o.Metadata.Namespace + "/" + o.Metadata.Name
+	for _, key := range keys {
+		key = strings.TrimSuffix(key, "/")
+		if flatten.IsChild(objectKey, key) || strings.HasPrefix(key+"/", objectKey+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type watchResponse struct {
+	waitIndex uint64
+	err       error
+}
+
+// currentVersion lists the watched resources and returns the newest
+// resourceVersion.
+func (c *Client) currentVersion(ctx context.Context, namespace string) (uint64, error) {
+	var version uint64
+	for _, kind := range kinds {
+		var list objectList
+		query := url.Values{"limit": []string{"1"}}
+		resp, err := c.request(ctx, resourcePath(kind, namespace, ""), query)
+		if err != nil {
+			return 0, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+		v, err := strconv.ParseUint(list.Metadata.ResourceVersion, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("kubernetes: unexpected resourceVersion %q", list.Metadata.ResourceVersion)
+		}
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// watch follows the watch stream of kind from resourceVersion and sends
+// the resourceVersion of the first event that affects keys to respChan.
+// It returns a resourceVersion of 0 when the version is too old to resume.
+func (c *Client) watch(ctx context.Context, kind, namespace string, keys []string, resourceVersion uint64, respChan chan watchResponse) {
+	send := func(r watchResponse) {
+		select {
+		case respChan <- r:
+		case <-ctx.Done():
+		}
+	}
+	for {
+		query := url.Values{
+			"watch":           []string{"1"},
+			"resourceVersion": []string{strconv.FormatUint(resourceVersion, 10)},
+		}
+		resp, err := c.request(ctx, resourcePath(kind, namespace, ""), query)
+		if err != nil {
+			send(watchResponse{resourceVersion, err})
+			return
+		}
+
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var ev watchEvent
+			if err := dec.Decode(&ev); err != nil {
+				resp.Body.Close()
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					send(watchResponse{resourceVersion, err})
+					return
+				}
+				// The API server closes watches after a timeout, resume
+				// where we left off.
+				break
+			}
+
+			if ev.Type == "ERROR" {
+				resp.Body.Close()
+				if ev.Object.Code == http.StatusGone {
+					log.Warning("kubernetes resourceVersion %d is too old, resyncing", resourceVersion)
+					send(watchResponse{0, nil})
+					return
+				}
+				send(watchResponse{resourceVersion, fmt.Errorf("kubernetes: watch error: %s", ev.Object.Message)})
+				return
+			}
+
+			if v, err := strconv.ParseUint(ev.Object.Metadata.ResourceVersion, 10, 64); err == nil {
+				resourceVersion = v
+			}
+			if ev.Type != "BOOKMARK" && affects(ev.Object, keys) {
+				resp.Body.Close()
+				send(watchResponse{resourceVersion, nil})
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// WatchPrefix follows the ConfigMap and Secret watch streams and returns
+// the resourceVersion of the first change to a watched key.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	namespace := watchNamespace(keys)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// return the current resourceVersion to trigger a key retrieval from
+	// the store and to give the next watch a version to resume from
+	if waitIndex == 0 {
+		return c.currentVersion(ctx, namespace)
+	}
+
+	respChan := make(chan watchResponse)
+	for _, kind := range kinds {
+		go c.watch(ctx, kind, namespace, keys, waitIndex, respChan)
+	}
+
+	select {
+	case <-stopChan:
+		return waitIndex, nil
+	case r := <-respChan:
+		return r.waitIndex, r.err
+	}
+}
@@ -0,0 +1,159 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeAPIServer serves a fixed set of ConfigMaps and Secrets and a watch
+// stream with a single event.
+func fakeAPIServer(t *testing.T) *httptest.Server {
+	configMap := map[string]interface{}{
+		"metadata": map[string]string{"name": "app", "namespace": "prod", "resourceVersion": "10"},
+		"data":     map[string]string{"log_level": "info", "port": "8080"},
+	}
+	secret := map[string]interface{}{
+		"metadata": map[string]string{"name": "app", "namespace": "prod", "resourceVersion": "11"},
+		"data":     map[string]string{"password": "cDRzc3cwcmQ="},
+	}
+	other := map[string]interface{}{
+		"metadata": map[string]string{"name": "other", "namespace": "prod", "resourceVersion": "13"},
+		"data":     map[string]string{"key": "value"},
+	}
+	updated := map[string]interface{}{
+		"metadata": map[string]string{"name": "app", "namespace": "prod", "resourceVersion": "14"},
+		"data":     map[string]string{"log_level": "debug", "port": "8080"},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/namespaces/prod/configmaps", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "1" {
+			if rv := r.URL.Query().Get("resourceVersion"); rv != "12" {
+				t.Errorf("watch resourceVersion = %s, want 12", rv)
+			}
+			enc := json.NewEncoder(w)
+			enc.Encode(map[string]interface{}{"type": "MODIFIED", "object": other})
+			enc.Encode(map[string]interface{}{"type": "MODIFIED", "object": updated})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"metadata": map[string]string{"resourceVersion": "12"},
+			"items":    []interface{}{configMap},
+		})
+	})
+	mux.HandleFunc("/api/v1/namespaces/prod/secrets", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "1" {
+			// Keep the stream open without events until the client leaves.
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"metadata": map[string]string{"resourceVersion": "12"},
+			"items":    []interface{}{secret},
+		})
+	})
+	mux.HandleFunc("/api/v1/namespaces/prod/configmaps/app", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(configMap)
+	})
+	mux.HandleFunc("/api/v1/namespaces/prod/secrets/app", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(secret)
+	})
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func newTestClient(t *testing.T, server string) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "confd-kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeconfig := filepath.Join(dir, "config")
+	data := fmt.Sprintf(`
+apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+users:
+- name: test
+  user:
+    token: abc
+`, server)
+	if err := ioutil.WriteFile(kubeconfig, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewKubernetesClient(nil, Options{Kubeconfig: kubeconfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() { os.RemoveAll(dir) }
+}
+
+func TestGetValues(t *testing.T) {
+	ts := fakeAPIServer(t)
+	defer ts.Close()
+	c, cleanup := newTestClient(t, ts.URL)
+	defer cleanup()
+
+	want := map[string]string{
+		"/prod/app/log_level": "info",
+		"/prod/app/port":      "8080",
+		"/prod/app/password":  "p4ssw0rd",
+	}
+	for _, keys := range [][]string{{"/prod"}, {"/prod/app"}} {
+		got, err := c.GetValues(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GetValues(%v) = %v, want %v", keys, got, want)
+		}
+	}
+
+	got, err := c.GetValues([]string{"/prod/app/port", "/prod/missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, map[string]string{"/prod/app/port": "8080"}) {
+		t.Errorf("GetValues() = %v", got)
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	ts := fakeAPIServer(t)
+	defer ts.Close()
+	c, cleanup := newTestClient(t, ts.URL)
+	defer cleanup()
+
+	stopChan := make(chan bool)
+	keys := []string{"/prod/app"}
+	index, err := c.WatchPrefix("/", keys, 0, stopChan)
+	if err != nil || index != 12 {
+		t.Fatalf("WatchPrefix() = %d, %v, want 12, nil", index, err)
+	}
+	index, err = c.WatchPrefix("/", keys, index, stopChan)
+	if err != nil || index != 14 {
+		t.Fatalf("WatchPrefix() = %d, %v, want 14, nil", index, err)
+	}
+}
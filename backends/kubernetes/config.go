@@ -0,0 +1,176 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// restConfig holds what is needed to talk to an API server.
+type restConfig struct {
+	host      string
+	token     string
+	username  string
+	password  string
+	tlsConfig *tls.Config
+}
+
+// inClusterConfig builds a restConfig from the service account that
+// Kubernetes mounts into every pod.
+func inClusterConfig() (*restConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running inside a kubernetes cluster and no kubeconfig given")
+	}
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found in the service account ca.crt")
+	}
+	return &restConfig{
+		host:      "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		tlsConfig: &tls.Config{RootCAs: pool},
+	}, nil
+}
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Username              string `yaml:"username"`
+			Password              string `yaml:"password"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// readData returns inline base64 data if set, the contents of file
+// otherwise. Relative files are resolved against dir.
+func readData(data, file, dir string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// kubeconfigConfig builds a restConfig from the current context of the
+// kubeconfig file at path.
+func kubeconfigConfig(path string) (*restConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(b, &kc); err != nil {
+		return nil, fmt.Errorf("cannot parse kubeconfig %s: %s", path, err)
+	}
+	dir := filepath.Dir(path)
+
+	var clusterName, userName string
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("context %q not found in kubeconfig %s", kc.CurrentContext, path)
+	}
+
+	rc := &restConfig{tlsConfig: &tls.Config{}}
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		rc.host = strings.TrimRight(c.Cluster.Server, "/")
+		rc.tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca, err := readData(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority, dir)
+		if err != nil {
+			return nil, err
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(ca)
+			rc.tlsConfig.RootCAs = pool
+		}
+	}
+	if rc.host == "" {
+		return nil, fmt.Errorf("cluster %q not found in kubeconfig %s", clusterName, path)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		rc.token, rc.username, rc.password = u.User.Token, u.User.Username, u.User.Password
+		if rc.token == "" && u.User.TokenFile != "" {
+			token, err := readData("", u.User.TokenFile, dir)
+			if err != nil {
+				return nil, err
+			}
+			rc.token = strings.TrimSpace(string(token))
+		}
+		cert, err := readData(u.User.ClientCertificateData, u.User.ClientCertificate, dir)
+		if err != nil {
+			return nil, err
+		}
+		key, err := readData(u.User.ClientKeyData, u.User.ClientKey, dir)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, err
+			}
+			rc.tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+	return rc, nil
+}
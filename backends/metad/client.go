@@ -3,6 +3,8 @@ package metad
 import (
 	"container/ring"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,28 +12,44 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
-	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/flatten"
 	"github.com/kelseyhightower/confd/log"
 )
 
 type Connection struct {
 	url        string
 	httpClient *http.Client
-	waitIndex  uint64
-	errTimes   uint32
+	token      string
+	// waitIndex and errTimes are shared by the watches, they are accessed
+	// atomically.
+	waitIndex uint64
+	errTimes  uint32
 }
 
-func (c *Connection) makeMetaDataRequest(path string) ([]byte, error) {
+func (c *Connection) newRequest(ctx context.Context, path string) (*http.Request, error) {
 	req, err := http.NewRequest("GET", strings.Join([]string{c.url, path}, ""), nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req.WithContext(ctx), nil
+}
+
+func (c *Connection) makeMetaDataRequest(path string) ([]byte, error) {
+	req, err := c.newRequest(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -39,20 +57,68 @@ func (c *Connection) makeMetaDataRequest(path string) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metad response status [%v] for %s, requestID: [%s]", resp.StatusCode, path, resp.Header.Get("X-Metad-RequestID"))
+	}
 	return ioutil.ReadAll(resp.Body)
 }
 
+// Options are the connection settings of the metad backend, taken from the
+// shared backend settings.
+type Options struct {
+	// Scheme reaches the nodes given without scheme, http by default.
+	Scheme string
+	// ClientCert, ClientKey and ClientCaKeys set up TLS for https nodes.
+	ClientCert   string
+	ClientKey    string
+	ClientCaKeys string
+	// AuthToken is sent as bearer token when it is set.
+	AuthToken string
+}
+
 type Client struct {
+	// mu guards connections and current, which are replaced by
+	// selectConnection while the watches and GetValues use them.
+	mu          sync.Mutex
 	connections *ring.Ring
 	current     *Connection
+	// selecting serializes selectConnection.
+	selecting sync.Mutex
 }
 
-func NewMetadClient(backendNodes []string) (*Client, error) {
+func init() {
+	backends.Register("metad", func(config backends.Config) (backends.StoreClient, error) {
+		return NewMetadClient(config.BackendNodes, Options{
+			Scheme:       config.Scheme,
+			ClientCert:   config.ClientCert,
+			ClientKey:    config.ClientKey,
+			ClientCaKeys: config.ClientCaKeys,
+			AuthToken:    config.AuthToken,
+		})
+	})
+}
+
+// NewMetadClient returns a client of the metad backendNodes, connecting to
+// them as set by opts.
+func NewMetadClient(backendNodes []string, opts Options) (*Client, error) {
+	tlsConfig, err := newTLSConfig(opts.ClientCert, opts.ClientKey, opts.ClientCaKeys)
+	if err != nil {
+		return nil, err
+	}
+	scheme := opts.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
 	connections := ring.New(len(backendNodes))
 	for _, backendNode := range backendNodes {
-		url := "http://" + backendNode
+		url := backendNode
+		if !strings.Contains(url, "://") {
+			url = scheme + "://" + backendNode
+		}
 		connection := &Connection{
-			url: url,
+			url:   strings.TrimSuffix(url, "/"),
+			token: opts.AuthToken,
 			httpClient: &http.Client{
 				Transport: &http.Transport{
 					Proxy: http.ProxyFromEnvironment,
@@ -60,6 +126,7 @@ func NewMetadClient(backendNodes []string) (*Client, error) {
 						KeepAlive: 1 * time.Second,
 						DualStack: true,
 					}).DialContext,
+					TLSClientConfig: tlsConfig,
 				},
 			},
 		}
@@ -71,22 +138,80 @@ func NewMetadClient(backendNodes []string) (*Client, error) {
 		connections: connections,
 	}
 
-	err := client.selectConnection()
+	err = client.selectConnection()
 
 	return client, err
 
 }
 
+// newTLSConfig returns the TLS configuration of the client certificate
+// and of the CA certificate, if any.
+func newTLSConfig(cert, key, caCert string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if cert != "" && key != "" {
+		clientCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	if caCert != "" {
+		ca, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// connection returns the connection in use.
+func (c *Client) connection() *Connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
 func (c *Client) selectConnection() error {
+	c.selecting.Lock()
+	defer c.selecting.Unlock()
+	return c.selectConnectionLocked()
+}
+
+// reselect selects another connection in place of conn, unless a
+// concurrent watch already did.
+func (c *Client) reselect(conn *Connection) {
+	c.selecting.Lock()
+	defer c.selecting.Unlock()
+	if c.connection() != conn {
+		return
+	}
+	if err := c.selectConnectionLocked(); err != nil {
+		log.Error("%s", err)
+	}
+}
+
+func (c *Client) selectConnectionLocked() error {
 	maxTime := 15 * time.Second
 	i := 1 * time.Second
 	for ; i < maxTime; i *= time.Duration(2) {
 		if conn, err := c.testConnection(); err == nil {
 			//found available conn
+			c.mu.Lock()
 			if c.current != nil {
 				atomic.StoreUint32(&c.current.errTimes, 0)
+				if c.current != conn {
+					// Its version is meaningless to the other servers,
+					// watches start over if it is selected again.
+					atomic.StoreUint64(&c.current.waitIndex, 0)
+				}
 			}
 			c.current = conn
+			c.mu.Unlock()
 			break
 		}
 		time.Sleep(i)
@@ -94,114 +219,121 @@ func (c *Client) selectConnection() error {
 	if i >= maxTime {
 		return fmt.Errorf("fail to connect any backend.")
 	}
-	log.Info("Using Metad URL: " + c.current.url)
+	log.Info("Using Metad URL: %s", c.connection().url)
 	return nil
 }
 
+// testConnection returns the next connection of the ring answering a
+// request, and moves the ring to it.
 func (c *Client) testConnection() (*Connection, error) {
+	c.mu.Lock()
+	connections := c.connections
 	//random start
 	if c.current == nil {
 		rand.Seed(time.Now().Unix())
-		r := rand.Intn(c.connections.Len())
-		c.connections = c.connections.Move(r)
+		r := rand.Intn(connections.Len())
+		connections = connections.Move(r)
 	}
-	c.connections = c.connections.Next()
-	conn := c.connections.Value.(*Connection)
+	c.mu.Unlock()
+
+	connections = connections.Next()
+	conn := connections.Value.(*Connection)
 	startConn := conn
 	_, err := conn.makeMetaDataRequest("/")
 	for err != nil {
 		log.Error("connection to [%s], error: [%v]", conn.url, err)
-		c.connections = c.connections.Next()
-		conn = c.connections.Value.(*Connection)
+		connections = connections.Next()
+		conn = connections.Value.(*Connection)
 		if conn == startConn {
 			break
 		}
 		_, err = conn.makeMetaDataRequest("/")
 	}
+
+	c.mu.Lock()
+	c.connections = connections
+	c.mu.Unlock()
 	return conn, err
 }
 
+// GetValues reads the values below keys with a single request of their
+// common prefix.
 func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	vars := map[string]string{}
+	if len(keys) == 0 {
+		return vars, nil
+	}
 
-	for _, key := range keys {
-		body, err := c.current.makeMetaDataRequest(key)
-		if err != nil {
-			atomic.AddUint32(&c.current.errTimes, 1)
-			return vars, err
-		}
+	conn := c.connection()
+	prefix := commonPrefix(keys)
+	body, err := conn.makeMetaDataRequest(prefix)
+	if err != nil {
+		atomic.AddUint32(&conn.errTimes, 1)
+		return vars, err
+	}
 
-		var jsonResponse interface{}
-		if err = json.Unmarshal(body, &jsonResponse); err != nil {
-			return vars, err
-		}
+	var jsonResponse interface{}
+	if err = json.Unmarshal(body, &jsonResponse); err != nil {
+		return vars, err
+	}
 
-		if err = treeWalk(key, jsonResponse, vars); err != nil {
-			return vars, err
+	all := map[string]string{}
+	flatten.Walk(prefix, jsonResponse, all)
+	for k, v := range all {
+		for _, key := range keys {
+			if flatten.IsChild(k, key) {
+				vars[k] = v
+				break
+			}
 		}
 	}
 	return vars, nil
 }
 
-func treeWalk(root string, val interface{}, vars map[string]string) error {
-	switch val.(type) {
-	case map[string]interface{}:
-		for k := range val.(map[string]interface{}) {
-			treeWalk(strings.Join([]string{root, k}, "/"), val.(map[string]interface{})[k], vars)
-		}
-	case []interface{}:
-		for i, item := range val.([]interface{}) {
-			idx := strconv.Itoa(i)
-			if i, isMap := item.(map[string]interface{}); isMap {
-				if name, exists := i["name"]; exists {
-					idx = name.(string)
-				}
-			}
-
-			treeWalk(strings.Join([]string{root, idx}, "/"), item, vars)
+// commonPrefix returns the deepest path that all keys are below.
+func commonPrefix(keys []string) string {
+	prefix := strings.Split(strings.TrimSuffix(keys[0], "/"), "/")
+	for _, key := range keys[1:] {
+		parts := strings.Split(strings.TrimSuffix(key, "/"), "/")
+		n := 0
+		for n < len(prefix) && n < len(parts) && prefix[n] == parts[n] {
+			n++
 		}
-	case bool:
-		vars[root] = strconv.FormatBool(val.(bool))
-	case string:
-		vars[root] = val.(string)
-	case float64:
-		vars[root] = strconv.FormatFloat(val.(float64), 'f', -1, 64)
-	case nil:
-		vars[root] = "null"
-	default:
-		log.Error("Unknown type: " + reflect.TypeOf(val).Name())
+		prefix = prefix[:n]
 	}
-	return nil
+	if p := strings.Join(prefix, "/"); p != "" {
+		return p
+	}
+	return "/"
 }
 
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
 
-	if c.current.errTimes >= 3 {
-		c.selectConnection()
+	if conn := c.connection(); atomic.LoadUint32(&conn.errTimes) >= 3 {
+		c.reselect(conn)
 	}
 
-	conn := c.current
+	conn := c.connection()
 
 	// return something > 0 to trigger a key retrieval from the store
 	if waitIndex == 0 {
-		conn.waitIndex = 1
-		return conn.waitIndex, nil
+		atomic.CompareAndSwapUint64(&conn.waitIndex, 0, 1)
+		return 1, nil
 	}
 	// when switch to anther server, so set waitIndex 0, and let server response current version.
-	if conn.waitIndex == 0 {
+	if atomic.LoadUint64(&conn.waitIndex) == 0 {
 		waitIndex = 0
 	}
 
 	done := make(chan struct{})
 	defer close(done)
 	ctx, cancel := context.WithCancel(context.Background())
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s%s?wait=true&prev_version=%d", conn.url, prefix, waitIndex), nil)
+	defer cancel()
+	req, err := conn.newRequest(ctx, fmt.Sprintf("%s?wait=true&prev_version=%d", prefix, waitIndex))
 	if err != nil {
-		return conn.waitIndex, err
+		return waitIndex, err
 	}
 
-	req.Header.Set("Accept", "application/json")
-	req = req.WithContext(ctx)
 	go func() {
 		select {
 		case <-stopChan:
@@ -216,25 +348,31 @@ func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, sto
 	if resp != nil {
 		defer resp.Body.Close()
 	}
+	if ctx.Err() != nil {
+		return waitIndex, nil
+	}
 	if err != nil {
 		log.Error("failed to connect to metad when watch prefix")
 		atomic.AddUint32(&conn.errTimes, 1)
-		return conn.waitIndex, err
+		return waitIndex, err
 	}
 	if resp.StatusCode != 200 {
-		return conn.waitIndex, errors.New(fmt.Sprintf("metad response status [%v], requestID: [%s]", resp.StatusCode, resp.Header.Get("X-Metad-RequestID")))
+		atomic.AddUint32(&conn.errTimes, 1)
+		return waitIndex, errors.New(fmt.Sprintf("metad response status [%v], requestID: [%s]", resp.StatusCode, resp.Header.Get("X-Metad-RequestID")))
 	}
+	index := waitIndex + 1
 	versionStr := resp.Header.Get("X-Metad-Version")
 	if versionStr != "" {
 		v, err := strconv.ParseUint(versionStr, 10, 64)
 		if err != nil {
 			log.Error("Parse X-Metad-Version %s error:%s", versionStr, err.Error())
+		} else {
+			index = v
 		}
-		conn.waitIndex = v
 	} else {
 		log.Warning("Metad response miss X-Metad-Version header.")
-		conn.waitIndex = conn.waitIndex + 1
 	}
-	return conn.waitIndex, nil
+	atomic.StoreUint64(&conn.waitIndex, index)
+	return index, nil
 
 }
@@ -0,0 +1,240 @@
+package metad
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeMetad serves a tree of metadata, recording the paths requested.
+type fakeMetad struct {
+	mu    sync.Mutex
+	paths []string
+	auth  []string
+}
+
+var metadata = map[string]interface{}{
+	"app": map[string]interface{}{
+		"database": map[string]interface{}{"host": "db", "port": 3306},
+		"upstream": map[string]interface{}{"app1": "10.0.1.10:8080"},
+		"secret":   "s3cr3t",
+	},
+}
+
+func (f *fakeMetad) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.paths = append(f.paths, r.URL.Path)
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	f.mu.Unlock()
+	var node interface{} = metadata
+	for _, name := range strings.Split(strings.Trim(r.URL.Path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		m, ok := node.(map[string]interface{})
+		if !ok || m[name] == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		node = m[name]
+	}
+	if r.URL.Query().Get("wait") == "true" {
+		w.Header().Set("X-Metad-Version", "7")
+	}
+	json.NewEncoder(w).Encode(node)
+}
+
+func TestGetValuesCommonPrefix(t *testing.T) {
+	f := &fakeMetad{}
+	s := httptest.NewTLSServer(f)
+	defer s.Close()
+
+	// The certificate of the server is the CA of the client.
+	dir, err := ioutil.TempDir("", "confd-metad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.TLS.Certificates[0].Certificate[0]})
+	if err := ioutil.WriteFile(ca, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewMetadClient([]string{s.Listener.Addr().String()}, Options{Scheme: "https", ClientCaKeys: ca, AuthToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	f.paths = nil
+	f.mu.Unlock()
+
+	vars, err := c.GetValues([]string{"/app/database", "/app/upstream/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/app/database/host": "db",
+		"/app/database/port": "3306",
+		"/app/upstream/app1": "10.0.1.10:8080",
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if want := []string{"/app"}; !reflect.DeepEqual(f.paths, want) {
+		t.Errorf("paths = %v, want %v", f.paths, want)
+	}
+	if f.auth[0] != "Bearer token" {
+		t.Errorf("Authorization = %q, want the bearer token", f.auth[0])
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{[]string{"/app/database"}, "/app/database"},
+		{[]string{"/app/database", "/app/db"}, "/app"},
+		{[]string{"/app/", "/app/upstream"}, "/app"},
+		{[]string{"/app", "/other"}, "/"},
+		{[]string{"/"}, "/"},
+	}
+	for _, tt := range tests {
+		if got := commonPrefix(tt.keys); got != tt.want {
+			t.Errorf("commonPrefix(%v) = %q, want %q", tt.keys, got, tt.want)
+		}
+	}
+}
+
+func TestConcurrentFailover(t *testing.T) {
+	var broken int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&broken) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		(&fakeMetad{}).ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+	healthy := httptest.NewServer(&fakeMetad{})
+	defer healthy.Close()
+
+	nodes := []string{flaky.Listener.Addr().String(), healthy.Listener.Addr().String()}
+	c, err := NewMetadClient(nodes, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.connection().url == healthy.URL {
+		// Start on the flaky node.
+		c.current.errTimes = 3
+		c.WatchPrefix("/", []string{"/app"}, 0, nil)
+	}
+	atomic.StoreInt32(&broken, 1)
+
+	// The watches and the reads run concurrently, as for several template
+	// resources, until they all switched to the healthy node.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				c.GetValues([]string{"/app"})
+				c.WatchPrefix("/app", []string{"/app"}, 1, make(chan bool))
+			}
+		}()
+	}
+	wg.Wait()
+	if url := c.connection().url; url != healthy.URL {
+		t.Errorf("connection = %s, want %s", url, healthy.URL)
+	}
+	if index, err := c.WatchPrefix("/app", []string{"/app"}, 1, make(chan bool)); err != nil || index != 7 {
+		t.Errorf("WatchPrefix() = %d, %v, want 7", index, err)
+	}
+}
+
+func TestWatchFailoverOnErrorStatus(t *testing.T) {
+	type node struct {
+		broken   int32
+		mu       sync.Mutex
+		versions []string
+		server   *httptest.Server
+	}
+	newNode := func() *node {
+		n := &node{}
+		n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("wait") == "true" {
+				n.mu.Lock()
+				n.versions = append(n.versions, r.URL.Query().Get("prev_version"))
+				n.mu.Unlock()
+				if atomic.LoadInt32(&n.broken) != 0 {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+			}
+			(&fakeMetad{}).ServeHTTP(w, r)
+		}))
+		return n
+	}
+	a, b := newNode(), newNode()
+	defer a.server.Close()
+	defer b.server.Close()
+	byURL := map[string]*node{a.server.URL: a, b.server.URL: b}
+
+	c, err := NewMetadClient([]string{a.server.URL, b.server.URL}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := byURL[c.connection().url]
+	if _, err := c.WatchPrefix("/app", []string{"/app"}, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := c.WatchPrefix("/app", []string{"/app"}, 1, nil); err != nil || index != 7 {
+		t.Fatalf("WatchPrefix() = %d, %v, want 7", index, err)
+	}
+
+	// Watches failing with an error status switch to the other node.
+	atomic.StoreInt32(&first.broken, 1)
+	for i := 0; i < 3; i++ {
+		if _, err := c.WatchPrefix("/app", []string{"/app"}, 7, nil); err == nil {
+			t.Fatal("WatchPrefix() succeeded on a broken node")
+		}
+	}
+	if _, err := c.WatchPrefix("/app", []string{"/app"}, 7, nil); err != nil {
+		t.Fatal(err)
+	}
+	second := byURL[c.connection().url]
+	if second == first {
+		t.Fatal("WatchPrefix() did not switch to the other node")
+	}
+
+	// Failing back, the version of the first node is not sent again.
+	atomic.StoreInt32(&first.broken, 0)
+	atomic.StoreInt32(&second.broken, 1)
+	for i := 0; i < 3; i++ {
+		c.WatchPrefix("/app", []string{"/app"}, 7, nil)
+	}
+	first.mu.Lock()
+	first.versions = nil
+	first.mu.Unlock()
+	if _, err := c.WatchPrefix("/app", []string{"/app"}, 7, nil); err != nil {
+		t.Fatal(err)
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	if len(first.versions) != 1 || first.versions[0] != "0" {
+		t.Errorf("prev_version after failing back = %v, want [0]", first.versions)
+	}
+}
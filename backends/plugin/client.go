@@ -0,0 +1,235 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/log"
+)
+
+// Options configures the plugin backend.
+type Options struct {
+	// Command is the plugin executable.
+	Command string `mapstructure:"command"`
+	// Args are passed to the plugin on its command line.
+	Args []string `mapstructure:"args"`
+	// Settings are passed to the plugin with the Configure request.
+	Settings map[string]interface{} `mapstructure:"settings"`
+}
+
+// Client forwards GetValues and WatchPrefix to a plugin process. The
+// process is started again if it exits.
+type Client struct {
+	opts  Options
+	nodes []string
+
+	mu   sync.Mutex
+	proc *process
+}
+
+// process is a running plugin.
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu      sync.Mutex
+	enc     *json.Encoder
+	nextID  uint64
+	pending map[uint64]chan Response
+	done    chan struct{}
+	err     error
+}
+
+func init() {
+	backends.Register("plugin", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return NewPluginClient(opts, config.BackendNodes)
+	})
+}
+
+// NewPluginClient starts the plugin and configures it with the backend
+// nodes and the plugin settings.
+func NewPluginClient(opts Options, nodes []string) (*Client, error) {
+	if opts.Command == "" {
+		return nil, errors.New("no command configured for the plugin backend, set it with -backend-option command=<path>")
+	}
+	c := &Client{opts: opts, nodes: nodes}
+	if _, err := c.process(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// process returns the running plugin, starting it if needed.
+func (c *Client) process() (*process, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.proc != nil {
+		select {
+		case <-c.proc.done:
+			log.Warning("Plugin %s exited: %s, restarting it", c.opts.Command, c.proc.err)
+		default:
+			return c.proc, nil
+		}
+	}
+
+	cmd := exec.Command(c.opts.Command, c.opts.Args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start plugin %s: %s", c.opts.Command, err)
+	}
+	log.Info("Started plugin %s", c.opts.Command)
+
+	p := &process{
+		cmd:     cmd,
+		stdin:   stdin,
+		enc:     json.NewEncoder(stdin),
+		pending: make(map[uint64]chan Response),
+		done:    make(chan struct{}),
+	}
+	go p.read(stdout)
+
+	params := ConfigureParams{Nodes: c.nodes, Settings: c.opts.Settings}
+	if err := p.call("Configure", params, nil, nil); err != nil {
+		p.stdin.Close()
+		return nil, fmt.Errorf("cannot configure plugin %s: %s", c.opts.Command, err)
+	}
+	c.proc = p
+	return p, nil
+}
+
+// read dispatches the responses of the plugin until its stdout is closed.
+func (p *process) read(stdout io.Reader) {
+	dec := json.NewDecoder(stdout)
+	var err error
+	for {
+		var resp Response
+		if err = dec.Decode(&resp); err != nil {
+			break
+		}
+		p.mu.Lock()
+		ch, ok := p.pending[resp.ID]
+		delete(p.pending, resp.ID)
+		p.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+	if err == io.EOF {
+		err = errors.New("unexpected end of output")
+	}
+	if werr := p.cmd.Wait(); werr != nil {
+		err = werr
+	}
+	p.err = fmt.Errorf("plugin exited: %s", err)
+	close(p.done)
+}
+
+// send sends a request under a new id. The answer is delivered on ch, or
+// ignored if ch is nil.
+func (p *process) send(method string, params interface{}, ch chan Response) (uint64, error) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextID++
+	id := p.nextID
+	if ch != nil {
+		p.pending[id] = ch
+	}
+	if err := p.enc.Encode(Request{ID: id, Method: method, Params: raw}); err != nil {
+		delete(p.pending, id)
+		return 0, err
+	}
+	return id, nil
+}
+
+// call sends a request and decodes its result into result. It returns
+// errStopped if stopChan fires first, after asking the plugin to cancel the
+// request.
+func (p *process) call(method string, params, result interface{}, stopChan chan bool) error {
+	ch := make(chan Response, 1)
+	id, err := p.send(method, params, ch)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-p.done:
+		return p.err
+	case <-stopChan:
+		p.forget(id)
+		p.send("Cancel", CancelParams{Target: id}, nil)
+		return errStopped
+	}
+}
+
+func (p *process) forget(id uint64) {
+	p.mu.Lock()
+	delete(p.pending, id)
+	p.mu.Unlock()
+}
+
+var errStopped = errors.New("plugin: request cancelled")
+
+// call runs a request against the plugin, starting it first if needed.
+func (c *Client) call(method string, params, result interface{}, stopChan chan bool) error {
+	p, err := c.process()
+	if err != nil {
+		return err
+	}
+	return p.call(method, params, result, stopChan)
+}
+
+// GetValues asks the plugin for the values of keys.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	var result GetValuesResult
+	if err := c.call("GetValues", GetValuesParams{Keys: keys}, &result, nil); err != nil {
+		return nil, err
+	}
+	if result.Values == nil {
+		result.Values = make(map[string]string)
+	}
+	return result.Values, nil
+}
+
+// WatchPrefix asks the plugin to wait for a change below prefix.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	var result WatchPrefixResult
+	params := WatchPrefixParams{Prefix: prefix, Keys: keys, WaitIndex: waitIndex}
+	err := c.call("WatchPrefix", params, &result, stopChan)
+	if err == errStopped {
+		return waitIndex, nil
+	}
+	if err != nil {
+		return waitIndex, err
+	}
+	return result.WaitIndex, nil
+}
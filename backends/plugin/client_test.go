@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary act as a plugin when it is started by
+// NewPluginClient.
+func TestMain(m *testing.M) {
+	if os.Getenv("CONFD_TEST_PLUGIN") == "1" {
+		if err := Serve(os.Stdin, os.Stdout, newFakeStore); err != nil {
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeStore serves the settings it was configured with.
+type fakeStore struct {
+	values map[string]string
+}
+
+func newFakeStore(nodes []string, settings map[string]interface{}) (Store, error) {
+	s := &fakeStore{values: map[string]string{"/nodes": strings.Join(nodes, ",")}}
+	for k, v := range settings {
+		s.values["/settings/"+k] = v.(string)
+	}
+	return s, nil
+}
+
+func (s *fakeStore) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, key := range keys {
+		switch key {
+		case "/fail":
+			return nil, errors.New("no such key")
+		case "/crash":
+			os.Exit(1)
+		}
+		for k, v := range s.values {
+			if strings.HasPrefix(k, key) {
+				vars[k] = v
+			}
+		}
+	}
+	return vars, nil
+}
+
+// WatchPrefix reports a change of /tick right away and blocks for anything
+// else.
+func (s *fakeStore) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if waitIndex == 0 || prefix == "/tick" {
+		return waitIndex + 1, nil
+	}
+	<-stopChan
+	return waitIndex, nil
+}
+
+func newTestClient(t *testing.T) *Client {
+	os.Setenv("CONFD_TEST_PLUGIN", "1")
+	defer os.Unsetenv("CONFD_TEST_PLUGIN")
+	opts := Options{
+		Command:  os.Args[0],
+		Settings: map[string]interface{}{"table": "config"},
+	}
+	c, err := NewPluginClient(opts, []string{"a:1", "b:2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGetValues(t *testing.T) {
+	c := newTestClient(t)
+
+	vars, err := c.GetValues([]string{"/nodes", "/settings"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"/nodes": "a:1,b:2", "/settings/table": "config"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+
+	if _, err := c.GetValues([]string{"/fail"}); err == nil || err.Error() != "no such key" {
+		t.Errorf("GetValues(/fail) error = %v, want no such key", err)
+	}
+}
+
+func TestRestartAfterExit(t *testing.T) {
+	c := newTestClient(t)
+	if _, err := c.GetValues([]string{"/crash"}); err == nil {
+		t.Fatal("GetValues(/crash) succeeded, want an error")
+	}
+
+	os.Setenv("CONFD_TEST_PLUGIN", "1")
+	defer os.Unsetenv("CONFD_TEST_PLUGIN")
+	vars, err := c.GetValues([]string{"/nodes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["/nodes"] != "a:1,b:2" {
+		t.Errorf("GetValues() = %v after a restart", vars)
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	c := newTestClient(t)
+	stopChan := make(chan bool)
+
+	index, err := c.WatchPrefix("/tick", []string{"/tick"}, 5, stopChan)
+	if err != nil || index != 6 {
+		t.Fatalf("WatchPrefix(/tick) = %d, %v, want 6", index, err)
+	}
+
+	done := make(chan uint64)
+	go func() {
+		index, err := c.WatchPrefix("/app", []string{"/app"}, 5, stopChan)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- index
+	}()
+	select {
+	case index := <-done:
+		t.Fatalf("WatchPrefix(/app) returned %d without a change", index)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The plugin keeps answering while a watch is pending.
+	if _, err := c.GetValues([]string{"/nodes"}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(stopChan)
+	select {
+	case index := <-done:
+		if index != 5 {
+			t.Errorf("stopped WatchPrefix() = %d, want 5", index)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WatchPrefix did not return after stopChan was closed")
+	}
+}
+
+func TestServeCancel(t *testing.T) {
+	in := strings.Join([]string{
+		`{"id":1,"method":"Configure","params":{"nodes":[],"settings":{}}}`,
+		`{"id":2,"method":"WatchPrefix","params":{"prefix":"/app","keys":["/app"],"waitIndex":5}}`,
+		`{"id":3,"method":"Cancel","params":{"target":2}}`,
+	}, "\n")
+	var out strings.Builder
+	if err := Serve(strings.NewReader(in), &out, newFakeStore); err != nil {
+		t.Fatal(err)
+	}
+	// The cancelled watch is not answered, the Cancel is under its own id.
+	want := "{\"id\":1,\"result\":{}}\n{\"id\":3,\"result\":{}}\n"
+	if out.String() != want {
+		t.Errorf("Serve() wrote %q, want %q", out.String(), want)
+	}
+}
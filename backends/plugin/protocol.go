@@ -0,0 +1,210 @@
+// Package plugin runs a backend as a separate process. confd and the plugin
+// exchange one JSON object per line over the plugin's stdin and stdout.
+//
+// confd sends requests:
+//
+//	{"id": 1, "method": "Configure", "params": {"nodes": ["..."], "settings": {...}}}
+//	{"id": 2, "method": "GetValues", "params": {"keys": ["/app"]}}
+//	{"id": 3, "method": "WatchPrefix", "params": {"prefix": "/app", "keys": ["/app/db"], "waitIndex": 7}}
+//	{"id": 4, "method": "Cancel", "params": {"target": 3}}
+//
+// and the plugin answers every request with its id, in any order:
+//
+//	{"id": 1, "result": {}}
+//	{"id": 2, "result": {"values": {"/app/db": "db.local"}}}
+//	{"id": 3, "result": {"waitIndex": 8}}
+//	{"id": 4, "result": {}}
+//	{"id": 2, "error": "connection refused"}
+//
+// Configure is always the first request. Requests may overlap, a plugin must
+// keep reading while a WatchPrefix is pending. Cancel asks the plugin to
+// abandon the pending WatchPrefix whose id is its target; the answer to the
+// WatchPrefix, if any, is ignored, and so is the answer to the Cancel. The
+// plugin's stderr is passed through to confd's. Plugins written in Go can
+// use Serve.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var errNotConfigured = errors.New("plugin: the plugin is not configured")
+
+func errUnknownMethod(method string) error {
+	return fmt.Errorf("plugin: unknown method %q", method)
+}
+
+// Request is a message sent from confd to the plugin.
+type Request struct {
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Response is the answer of the plugin to a request.
+type Response struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// ConfigureParams are the parameters of a Configure request.
+type ConfigureParams struct {
+	Nodes    []string               `json:"nodes"`
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// GetValuesParams are the parameters of a GetValues request.
+type GetValuesParams struct {
+	Keys []string `json:"keys"`
+}
+
+// GetValuesResult is the result of a GetValues request.
+type GetValuesResult struct {
+	Values map[string]string `json:"values"`
+}
+
+// WatchPrefixParams are the parameters of a WatchPrefix request.
+type WatchPrefixParams struct {
+	Prefix    string   `json:"prefix"`
+	Keys      []string `json:"keys"`
+	WaitIndex uint64   `json:"waitIndex"`
+}
+
+// WatchPrefixResult is the result of a WatchPrefix request.
+type WatchPrefixResult struct {
+	WaitIndex uint64 `json:"waitIndex"`
+}
+
+// CancelParams are the parameters of a Cancel request.
+type CancelParams struct {
+	// Target is the id of the WatchPrefix request to cancel.
+	Target uint64 `json:"target"`
+}
+
+// Store is the interface a plugin served with Serve implements. It matches
+// the backends.StoreClient interface.
+type Store interface {
+	GetValues(keys []string) (map[string]string, error)
+	WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error)
+}
+
+// Serve answers the requests read from r on w until r is closed. The store
+// is created by configure from the parameters of the Configure request.
+// A plugin's main function typically ends with:
+//
+//	if err := plugin.Serve(os.Stdin, os.Stdout, newStore); err != nil {
+//		log.Fatal(err)
+//	}
+func Serve(r io.Reader, w io.Writer, configure func(nodes []string, settings map[string]interface{}) (Store, error)) error {
+	var (
+		mu      sync.Mutex
+		enc     = json.NewEncoder(w)
+		store   Store
+		watches = make(map[uint64]chan bool)
+		wg      sync.WaitGroup
+	)
+	reply := func(id uint64, result interface{}, err error) {
+		resp := Response{ID: id}
+		if err != nil {
+			resp.Error = err.Error()
+		} else if resp.Result, err = json.Marshal(result); err != nil {
+			resp.Error = err.Error()
+		}
+		mu.Lock()
+		enc.Encode(resp)
+		mu.Unlock()
+	}
+	defer func() {
+		mu.Lock()
+		for id, stopChan := range watches {
+			close(stopChan)
+			delete(watches, id)
+		}
+		mu.Unlock()
+		wg.Wait()
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var req Request
+		if err := dec.Decode(&req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if req.Method != "Configure" && store == nil {
+			reply(req.ID, nil, errNotConfigured)
+			continue
+		}
+		switch req.Method {
+		case "Configure":
+			var p ConfigureParams
+			if err := json.Unmarshal(req.Params, &p); err != nil {
+				reply(req.ID, nil, err)
+				continue
+			}
+			s, err := configure(p.Nodes, p.Settings)
+			if err == nil {
+				store = s
+			}
+			reply(req.ID, struct{}{}, err)
+		case "GetValues":
+			var p GetValuesParams
+			if err := json.Unmarshal(req.Params, &p); err != nil {
+				reply(req.ID, nil, err)
+				continue
+			}
+			wg.Add(1)
+			go func(id uint64, s Store) {
+				defer wg.Done()
+				values, err := s.GetValues(p.Keys)
+				reply(id, GetValuesResult{values}, err)
+			}(req.ID, store)
+		case "WatchPrefix":
+			var p WatchPrefixParams
+			if err := json.Unmarshal(req.Params, &p); err != nil {
+				reply(req.ID, nil, err)
+				continue
+			}
+			stopChan := make(chan bool)
+			mu.Lock()
+			watches[req.ID] = stopChan
+			mu.Unlock()
+			wg.Add(1)
+			go func(id uint64, s Store) {
+				defer wg.Done()
+				index, err := s.WatchPrefix(p.Prefix, p.Keys, p.WaitIndex, stopChan)
+				mu.Lock()
+				_, pending := watches[id]
+				delete(watches, id)
+				mu.Unlock()
+				if pending {
+					reply(id, WatchPrefixResult{index}, err)
+				}
+			}(req.ID, store)
+		case "Cancel":
+			var p CancelParams
+			if err := json.Unmarshal(req.Params, &p); err != nil {
+				reply(req.ID, nil, err)
+				continue
+			}
+			mu.Lock()
+			if stopChan, ok := watches[p.Target]; ok {
+				close(stopChan)
+				delete(watches, p.Target)
+			}
+			mu.Unlock()
+			reply(req.ID, struct{}{}, nil)
+		default:
+			reply(req.ID, nil, errUnknownMethod(req.Method))
+		}
+	}
+}
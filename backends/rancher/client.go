@@ -1,37 +1,85 @@
 package rancher
 
 import (
+	"bytes"
+	"container/ring"
+	"context"
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
-	"reflect"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/changes"
+	"github.com/kelseyhightower/confd/backends/flatten"
 	log "github.com/kelseyhightower/confd/log"
 )
 
 const (
 	MetaDataURL = "http://rancher-metadata"
+	// maxWait is the number of seconds a version long poll is held by the
+	// metadata service before it answers with the same version.
+	maxWait = 60
 )
 
 type Client struct {
-	url        string
 	httpClient *http.Client
+
+	mu sync.Mutex
+	// nodes is a ring of the metadata URLs, positioned on the one in use.
+	nodes *ring.Ring
+	// versions holds the version of the metadata at the indexes returned
+	// by WatchPrefix.
+	versions *changes.States
 }
 
-func NewRancherClient(backendNodes []string) (*Client, error) {
-	url := MetaDataURL
+// statusError is an unexpected HTTP status of the metadata service.
+type statusError struct {
+	url    string
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s responded with status %d", e.url, e.status)
+}
 
+func init() {
+	backends.Register("rancher", func(config backends.Config) (backends.StoreClient, error) {
+		return NewRancherClient(config.BackendNodes)
+	})
+}
+
+// NewRancherClient returns a client of the metadata service at
+// backendNodes, starting with a random one and failing over to the next
+// ones.
+func NewRancherClient(backendNodes []string) (*Client, error) {
+	urls := []string{MetaDataURL}
 	if len(backendNodes) > 0 {
-		url = "http://" + backendNodes[0]
+		urls = nil
+		for _, node := range backendNodes {
+			urls = append(urls, "http://"+node)
+		}
+	}
+
+	nodes := ring.New(len(urls))
+	for _, u := range urls {
+		nodes.Value = u
+		nodes = nodes.Next()
 	}
+	nodes = nodes.Move(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(len(urls)))
 
-	log.Info("Using Rancher Metadata URL: " + url)
+	log.Info("Using Rancher Metadata URLs: %s", strings.Join(urls, ", "))
 	client := &Client{
-		url:        url,
 		httpClient: &http.Client{},
+		nodes:      nodes,
+		versions:   changes.NewStates(),
 	}
 
 	err := client.testConnection()
@@ -43,7 +91,7 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	vars := map[string]string{}
 
 	for _, key := range keys {
-		body, err := c.makeMetaDataRequest(key)
+		body, err := c.makeMetaDataRequest(context.Background(), key)
 		if err != nil {
 			return vars, err
 		}
@@ -53,54 +101,57 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 			return vars, err
 		}
 
-		if err = treeWalk(key, jsonResponse, vars); err != nil {
-			return vars, err
-		}
+		flatten.Walk(key, jsonResponse, vars)
 	}
 	return vars, nil
 }
 
-func treeWalk(root string, val interface{}, vars map[string]string) error {
-	switch val.(type) {
-	case map[string]interface{}:
-		for k := range val.(map[string]interface{}) {
-			treeWalk(strings.Join([]string{root, k}, "/"), val.(map[string]interface{})[k], vars)
-		}
-	case []interface{}:
-		for i, item := range val.([]interface{}) {
-			idx := strconv.Itoa(i)
-			if i, isMap := item.(map[string]interface{}); isMap {
-				if name, exists := i["name"]; exists {
-					idx = name.(string)
-				}
-			}
+// makeMetaDataRequest requests path from the node in use. If the node
+// cannot be reached or fails, the request is sent to the next nodes of the
+// ring, and the first one answering is used from then on.
+func (c *Client) makeMetaDataRequest(ctx context.Context, path string) ([]byte, error) {
+	c.mu.Lock()
+	node := c.nodes
+	c.mu.Unlock()
 
-			treeWalk(strings.Join([]string{root, idx}, "/"), item, vars)
+	var err error
+	for i := 0; i < node.Len(); i++ {
+		var body []byte
+		body, err = c.get(ctx, node.Value.(string)+path)
+		if e, ok := err.(*statusError); err == nil || ok && e.status < http.StatusInternalServerError {
+			c.mu.Lock()
+			if c.nodes != node {
+				log.Info("Using Rancher Metadata URL: %s", node.Value)
+				c.nodes = node
+			}
+			c.mu.Unlock()
+			return body, err
+		}
+		if ctx.Err() != nil {
+			return nil, err
 		}
-	case bool:
-		vars[root] = strconv.FormatBool(val.(bool))
-	case string:
-		vars[root] = val.(string)
-	case float64:
-		vars[root] = strconv.FormatFloat(val.(float64), 'f', -1, 64)
-	case nil:
-		vars[root] = "null"
-	default:
-		log.Error("Unknown type: " + reflect.TypeOf(val).Name())
+		log.Error("Rancher Metadata request failed: %s", err)
+		node = node.Next()
 	}
-	return nil
+	return nil, err
 }
 
-func (c *Client) makeMetaDataRequest(path string) ([]byte, error) {
-	req, _ := http.NewRequest("GET", strings.Join([]string{c.url, path}, ""), nil)
+func (c *Client) get(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{u, resp.StatusCode}
+	}
 	return ioutil.ReadAll(resp.Body)
 }
 
@@ -109,7 +160,7 @@ func (c *Client) testConnection() error {
 	maxTime := 20 * time.Second
 
 	for i := 1 * time.Second; i < maxTime; i *= time.Duration(2) {
-		if _, err = c.makeMetaDataRequest("/"); err != nil {
+		if _, err = c.makeMetaDataRequest(context.Background(), "/"); err != nil {
 			time.Sleep(i)
 		} else {
 			return nil
@@ -118,8 +169,74 @@ func (c *Client) testConnection() error {
 	return err
 }
 
+// index returns the index of version, which is the version itself when it
+// is a number, and remembers the version of the index.
+func (c *Client) index(version string) uint64 {
+	index, err := strconv.ParseUint(version, 10, 64)
+	if err != nil {
+		h := fnv.New64a()
+		h.Write([]byte(version))
+		index = h.Sum64()
+	}
+	return c.versions.Put(index, version)
+}
+
+// WatchPrefix long polls the version of the metadata of the API version
+// prefix starts with, until it differs from the one of waitIndex. It
+// returns the new version as index.
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	// Watches are not implemented in Rancher Metadata Service
-	<-stopChan
-	return 0, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	state, ok := c.versions.Get(waitIndex)
+	version, _ := state.(string)
+	for {
+		// Without the version of waitIndex, the current version is
+		// compared to it.
+		path := versionPath(prefix)
+		if waitIndex != 0 && ok {
+			path += fmt.Sprintf("?wait=true&value=%s&maxWait=%d", url.QueryEscape(version), maxWait)
+		}
+		body, err := c.makeMetaDataRequest(ctx, path)
+		if ctx.Err() != nil {
+			return waitIndex, nil
+		}
+		if err != nil {
+			return waitIndex, err
+		}
+		version, ok = parseVersion(body), true
+		if index := c.index(version); index != waitIndex {
+			return index, nil
+		}
+	}
+}
+
+// versionPath returns the path of the metadata version of the API version
+// heading prefix, such as /2015-12-19/version, or of the latest API version
+// when prefix is empty.
+func versionPath(prefix string) string {
+	root := strings.SplitN(strings.TrimPrefix(prefix, "/"), "/", 2)[0]
+	if root == "" {
+		root = "latest"
+	}
+	return "/" + root + "/version"
+}
+
+// parseVersion returns the version of a /version response, a JSON string
+// or number, or else plain text.
+func parseVersion(body []byte) string {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return strings.TrimSpace(string(body))
+	}
+	return fmt.Sprint(v)
 }
@@ -0,0 +1,162 @@
+package rancher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetadata serves the metadata of a service and its version, holding
+// the version long polls until the version changes.
+type fakeMetadata struct {
+	mu      sync.Mutex
+	version int
+	changed chan struct{}
+	polls   []string
+}
+
+func newFakeMetadata() *fakeMetadata {
+	return &fakeMetadata{version: 1, changed: make(chan struct{})}
+}
+
+func (f *fakeMetadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	switch r.URL.Path {
+	case "/":
+		// The client only checks that the root answers.
+		enc.Encode([]string{})
+	case "/self/service":
+		enc.Encode(map[string]interface{}{"name": "web", "ports": []string{"80:80"}})
+	case "/latest/version":
+		f.mu.Lock()
+		version, changed := strconv.Itoa(f.version), f.changed
+		if r.URL.Query().Get("wait") == "true" {
+			f.polls = append(f.polls, r.URL.RawQuery)
+		}
+		f.mu.Unlock()
+		if r.URL.Query().Get("wait") == "true" && r.URL.Query().Get("value") == version {
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			f.mu.Lock()
+			version = strconv.Itoa(f.version)
+			f.mu.Unlock()
+		}
+		enc.Encode(version)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeMetadata) bump() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// newTestClient serves each handler as a node and returns a client of the
+// nodes and their URLs.
+func newTestClient(t *testing.T, handlers ...http.Handler) (*Client, []string, func()) {
+	var servers []*httptest.Server
+	var nodes, urls []string
+	stop := func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+	for _, h := range handlers {
+		s := httptest.NewServer(h)
+		servers = append(servers, s)
+		nodes = append(nodes, strings.TrimPrefix(s.URL, "http://"))
+		urls = append(urls, s.URL)
+	}
+	c, err := NewRancherClient(nodes)
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	return c, urls, stop
+}
+
+func TestGetValuesFailover(t *testing.T) {
+	down := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	c, urls, stop := newTestClient(t, down, newFakeMetadata())
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		vars, err := c.GetValues([]string{"/self/service"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"/self/service/name": "web", "/self/service/ports/0": "80:80"}
+		if !reflect.DeepEqual(vars, want) {
+			t.Errorf("GetValues() = %v, want %v", vars, want)
+		}
+	}
+	if c.nodes.Value != urls[1] {
+		t.Errorf("node in use = %v, want %s", c.nodes.Value, urls[1])
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	f := newFakeMetadata()
+	c, _, stop := newTestClient(t, f)
+	defer stop()
+	stopChan := make(chan bool)
+
+	index, err := c.WatchPrefix("/latest/self", []string{"/latest/self/service"}, 0, stopChan)
+	if err != nil || index != 1 {
+		t.Fatalf("WatchPrefix() = %d, %v, want the version 1", index, err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		f.bump()
+	}()
+	start := time.Now()
+	index, err = c.WatchPrefix("/latest/self", []string{"/latest/self/service"}, index, stopChan)
+	if err != nil || index != 2 {
+		t.Fatalf("WatchPrefix() = %d, %v, want the version 2", index, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("WatchPrefix() returned after %s, before the version changed", elapsed)
+	}
+	f.mu.Lock()
+	if want := []string{"wait=true&value=1&maxWait=60"}; !reflect.DeepEqual(f.polls, want) {
+		t.Errorf("polls = %v, want %v", f.polls, want)
+	}
+	f.mu.Unlock()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(stopChan)
+	}()
+	if next, err := c.WatchPrefix("/latest/self", []string{"/latest/self/service"}, index, stopChan); err != nil || next != index {
+		t.Errorf("WatchPrefix() = %d, %v, want %d once stopped", next, err, index)
+	}
+}
+
+func TestVersionPath(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"":                          "/latest/version",
+		"/":                         "/latest/version",
+		"/latest":                   "/latest/version",
+		"/2015-12-19/self/service/": "/2015-12-19/version",
+	} {
+		if got := versionPath(prefix); got != want {
+			t.Errorf("versionPath(%q) = %s, want %s", prefix, got, want)
+		}
+	}
+}
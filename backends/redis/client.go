@@ -1,46 +1,102 @@
 package redis
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/garyburd/redigo/redis"
+	"github.com/kelseyhightower/confd/backends"
 	"github.com/kelseyhightower/confd/log"
+	"io/ioutil"
+	"net"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Options are the redis specific settings, set as backend options.
+type Options struct {
+	// Database is the index of the database to read. Redis Cluster only
+	// has database 0.
+	Database int `mapstructure:"database"`
+	// SentinelMaster is the name of the master monitored by the sentinels
+	// given as nodes. The master is looked up again on every reconnection,
+	// so that failovers are followed.
+	SentinelMaster string `mapstructure:"sentinel_master"`
+	// SentinelPassword authenticates with the sentinels.
+	SentinelPassword string `mapstructure:"sentinel_password"`
+	// Cluster makes the nodes the seeds of a Redis Cluster. Keys are read
+	// from the master serving their slot and scans run on every master.
+	Cluster bool `mapstructure:"cluster"`
+	// TLS connects with TLS, which is implied by a client certificate or a
+	// CA certificate.
+	TLS bool `mapstructure:"tls"`
+	// WatchChannel is a pub/sub channel announcing changes, for servers
+	// without keyspace notifications. A message whose payload is a key
+	// starting with "/" only wakes up the watches of that key, any other
+	// message wakes up all of them.
+	WatchChannel string `mapstructure:"watch_channel"`
+}
+
 // Client is a wrapper around the redis client
 type Client struct {
-	client   redis.Conn
-	machines []string
-	password string
+	// mu serializes the commands of the templates sharing the client.
+	mu        sync.Mutex
+	client    redis.Conn
+	cluster   *cluster
+	machines  []string
+	password  string
+	tlsConfig *tls.Config
+	opts      Options
+
+	watcherOnce sync.Once
+	watcher     *watcher
 }
 
-// Iterate through `machines`, trying to connect to each in turn.
-// Returns the first successful connection or the last error encountered.
-// Assumes that `machines` is non-empty.
-func tryConnect(machines []string, password string) (redis.Conn, error) {
-	var err error
-	for _, address := range machines {
-		var conn redis.Conn
-		network := "tcp"
-		if _, err = os.Stat(address); err == nil {
-			network = "unix"
-		}
-		log.Debug(fmt.Sprintf("Trying to connect to redis node %s", address))
+// dial connects to the node at address, a unix socket if such a file
+// exists, and selects database db.
+func (c *Client) dial(address, password string, db int, readTimeout time.Duration) (redis.Conn, error) {
+	network := "tcp"
+	if _, err := os.Stat(address); err == nil {
+		network = "unix"
+	}
+	log.Debug("Trying to connect to redis node %s", address)
 
-		dialops := []redis.DialOption{
-			redis.DialConnectTimeout(time.Second),
-			redis.DialReadTimeout(time.Second),
-			redis.DialWriteTimeout(time.Second),
-		}
+	dialops := []redis.DialOption{
+		redis.DialConnectTimeout(time.Second),
+		redis.DialReadTimeout(readTimeout),
+		redis.DialWriteTimeout(time.Second),
+		redis.DialDatabase(db),
+	}
 
-		if password != "" {
-			dialops = append(dialops, redis.DialPassword(password))
-		}
+	if password != "" {
+		dialops = append(dialops, redis.DialPassword(password))
+	}
+
+	if c.tlsConfig != nil && network == "tcp" {
+		dialops = append(dialops, redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+			// The server name is taken from addr unless the config has one.
+			return tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, network, addr, c.tlsConfig)
+		}))
+	}
 
-		conn, err = redis.Dial(network, address, dialops...)
+	return redis.Dial(network, address, dialops...)
+}
 
+// Iterate through `machines`, trying to connect to each in turn, or to the
+// master the sentinels point to if a sentinel master is configured.
+// Returns the first successful connection or the last error encountered.
+// Assumes that `machines` is non-empty.
+func (c *Client) tryConnect(readTimeout time.Duration) (redis.Conn, error) {
+	if c.opts.SentinelMaster != "" {
+		return c.connectMaster(readTimeout)
+	}
+	var err error
+	for _, address := range c.machines {
+		var conn redis.Conn
+		conn, err = c.dial(address, c.password, c.opts.Database, readTimeout)
 		if err != nil {
 			continue
 		}
@@ -57,9 +113,10 @@ func (c *Client) connectedClient() (redis.Conn, error) {
 		log.Debug("Testing existing redis connection.")
 
 		resp, err := c.client.Do("PING")
-		if (err != nil && err == redis.ErrNil) || resp != "PONG" {
-			log.Error(fmt.Sprintf("Existing redis connection no longer usable. "+
-				"Will try to re-establish. Error: %s", err.Error()))
+		if err != nil || resp != "PONG" {
+			log.Error("Existing redis connection no longer usable. "+
+				"Will try to re-establish. Error: %v", err)
+			c.client.Close()
 			c.client = nil
 		}
 	}
@@ -67,7 +124,7 @@ func (c *Client) connectedClient() (redis.Conn, error) {
 	// Existing client could have been deleted by previous block
 	if c.client == nil {
 		var err error
-		c.client, err = tryConnect(c.machines, c.password)
+		c.client, err = c.tryConnect(time.Second)
 		if err != nil {
 			return nil, err
 		}
@@ -76,35 +133,108 @@ func (c *Client) connectedClient() (redis.Conn, error) {
 	return c.client, nil
 }
 
+func init() {
+	backends.Register("redis", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		password, key := config.Password, config.ClientKey
+		if password == "" && key != "" && config.ClientCert == "" {
+			// The password used to be read from client_key, which is a TLS
+			// key only along with a client certificate.
+			log.Warning("Reading the redis password from client_key is deprecated, set password instead")
+			password, key = key, ""
+		}
+		return NewRedisClient(config.BackendNodes, password,
+			config.ClientCert, key, config.ClientCaKeys, opts)
+	})
+}
+
 // NewRedisClient returns an *redis.Client with a connection to named machines.
+// The connection uses TLS if opts say so or if a client certificate or a CA
+// certificate is given.
 // It returns an error if a connection to the cluster cannot be made.
-func NewRedisClient(machines []string, password string) (*Client, error) {
+func NewRedisClient(machines []string, password, cert, key, caCert string, opts Options) (*Client, error) {
+	if opts.Cluster && opts.SentinelMaster != "" {
+		return nil, errors.New("redis cluster and sentinel cannot be used together")
+	}
+	if opts.Cluster && opts.Database != 0 {
+		return nil, errors.New("redis cluster only has database 0")
+	}
+
+	clientWrapper := &Client{machines: machines, password: password, opts: opts, client: nil}
+	if opts.TLS || cert != "" || caCert != "" {
+		tlsConfig := &tls.Config{}
+		if cert != "" && key != "" {
+			clientCert, err := tls.LoadX509KeyPair(cert, key)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{clientCert}
+		}
+		if caCert != "" {
+			ca, err := ioutil.ReadFile(caCert)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			tlsConfig.RootCAs.AppendCertsFromPEM(ca)
+		}
+		clientWrapper.tlsConfig = tlsConfig
+	}
+
+	if opts.Cluster {
+		clientWrapper.cluster = &cluster{client: clientWrapper, conns: make(map[string]redis.Conn)}
+		return clientWrapper, clientWrapper.cluster.refresh()
+	}
 	var err error
-	clientWrapper := &Client{machines: machines, password: password, client: nil}
-	clientWrapper.client, err = tryConnect(machines, password)
+	clientWrapper.client, err = clientWrapper.tryConnect(time.Second)
 	return clientWrapper, err
 }
 
 // GetValues queries redis for keys prefixed by prefix.
 func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cluster != nil {
+		vars, err := c.cluster.getValues(keys)
+		if err != nil {
+			// The slots may have moved, or a master failed over.
+			log.Debug("Reloading the redis cluster slots after error: %s", err)
+			c.cluster.reset()
+			vars, err = c.cluster.getValues(keys)
+		}
+		return vars, err
+	}
+
 	// Ensure we have a connected redis client
 	rClient, err := c.connectedClient()
 	if err != nil && err != redis.ErrNil {
 		return nil, err
 	}
+	connFor := func(string) (redis.Conn, error) { return rClient, nil }
+	return getValues(keys, connFor, []redis.Conn{rClient})
+}
 
+// getValues reads keys, each through the connection connFor returns for
+// it, and scans each of scanConns for the keys below them.
+func getValues(keys []string, connFor func(key string) (redis.Conn, error), scanConns []redis.Conn) (map[string]string, error) {
 	vars := make(map[string]string)
 	for _, key := range keys {
 		key = strings.Replace(key, "/*", "", -1)
-		value, err := redis.String(rClient.Do("GET", key))
-		if err == nil {
-			vars[key] = value
-			continue
+		conn, err := connFor(key)
+		if err != nil {
+			return vars, err
 		}
-
-		if err != redis.ErrNil {
+		found, err := readKey(conn, key, vars)
+		if err != nil {
 			return vars, err
 		}
+		if found {
+			continue
+		}
 
 		if key == "/" {
 			key = "/*"
@@ -112,33 +242,164 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 			key = fmt.Sprintf("%s/*", key)
 		}
 
-		idx := 0
-		for {
-			values, err := redis.Values(rClient.Do("SCAN", idx, "MATCH", key, "COUNT", "1000"))
-			if err != nil && err != redis.ErrNil {
+		for _, conn := range scanConns {
+			if err := scan(conn, key, vars); err != nil {
 				return vars, err
 			}
-			idx, _ = redis.Int(values[0], nil)
-			items, _ := redis.Strings(values[1], nil)
-			for _, item := range items {
-				var newKey string
-				if newKey, err = redis.String(item, nil); err != nil {
-					return vars, err
-				}
-				if value, err = redis.String(rClient.Do("GET", newKey)); err == nil {
-					vars[newKey] = value
-				}
-			}
-			if idx == 0 {
-				break
-			}
 		}
 	}
 	return vars, nil
 }
 
-// WatchPrefix is not yet implemented.
+// scan reads the keys of the node of conn matching pattern.
+func scan(conn redis.Conn, pattern string, vars map[string]string) error {
+	idx := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", idx, "MATCH", pattern, "COUNT", "1000"))
+		if err != nil {
+			return err
+		}
+		idx, _ = redis.Int(values[0], nil)
+		items, _ := redis.Strings(values[1], nil)
+		for _, item := range items {
+			if _, err := readKey(conn, item, vars); err != nil {
+				return err
+			}
+		}
+		if idx == 0 {
+			return nil
+		}
+	}
+}
+
+// readKey stores the value of key in vars according to its type: strings
+// under the key itself, hash fields under /key/field, list elements under
+// /key/0 to /key/n and set members under /key/member. Other types are
+// skipped. It reports whether the key exists.
+func readKey(conn redis.Conn, key string, vars map[string]string) (bool, error) {
+	kind, err := redis.String(conn.Do("TYPE", key))
+	if err != nil {
+		return false, err
+	}
+	base := strings.TrimSuffix(key, "/")
+	switch kind {
+	case "none":
+		return false, nil
+	case "string":
+		value, err := redis.String(conn.Do("GET", key))
+		if err == redis.ErrNil {
+			// The key expired since TYPE.
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		vars[key] = value
+	case "hash":
+		fields, err := redis.StringMap(conn.Do("HGETALL", key))
+		if err != nil {
+			return false, err
+		}
+		for field, value := range fields {
+			vars[base+"/"+field] = value
+		}
+	case "list":
+		items, err := redis.Strings(conn.Do("LRANGE", key, 0, -1))
+		if err != nil {
+			return false, err
+		}
+		for i, item := range items {
+			vars[fmt.Sprintf("%s/%d", base, i)] = item
+		}
+	case "set":
+		members, err := redis.Strings(conn.Do("SMEMBERS", key))
+		if err != nil {
+			return false, err
+		}
+		for _, member := range members {
+			vars[base+"/"+member] = member
+		}
+	default:
+		log.Debug("Skipping redis key %s of type %s", key, kind)
+	}
+	return true, nil
+}
+
+// WatchPrefix blocks until a key below keys changes after waitIndex, as
+// announced by keyspace notifications or by messages on the watch channel,
+// and returns the index of the change.
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	<-stopChan
-	return 0, nil
+	c.watcherOnce.Do(func() {
+		c.watcher = newWatcher(c.watchDials(), c.opts.WatchChannel, c.opts.Database)
+	})
+
+	if waitIndex == 0 {
+		return c.watcher.Current(), nil
+	}
+
+	c.watcher.watch(prefix)
+	return c.watcher.Wait(keys, waitIndex, stopChan), nil
+}
+
+// watchDials returns the functions connecting the subscriptions of the
+// watcher: one to the master, or one per master of a cluster since keyspace
+// notifications are local to each node. The masters of a cluster are those
+// known when the first watch starts.
+func (c *Client) watchDials() []func() (redis.Conn, error) {
+	// Pings are sent every pingInterval, a longer silence means the
+	// connection is gone.
+	connect := func() (redis.Conn, error) { return c.tryConnect(2 * pingInterval) }
+	if c.opts.WatchChannel != "" {
+		// Cluster nodes forward published messages to each other.
+		return []func() (redis.Conn, error){connect}
+	}
+
+	var addresses []string
+	if c.cluster != nil {
+		c.mu.Lock()
+		masters, err := c.cluster.masters()
+		c.mu.Unlock()
+		if err != nil {
+			log.Error("Cannot list the redis cluster masters, watching the seed nodes only: %s", err)
+		}
+		addresses = masters
+	}
+
+	var dials []func() (redis.Conn, error)
+	for _, address := range addresses {
+		address := address
+		dials = append(dials, func() (redis.Conn, error) {
+			return c.dial(address, c.password, 0, 2*pingInterval)
+		})
+	}
+	if len(dials) == 0 {
+		dials = append(dials, connect)
+	}
+	for i, dial := range dials {
+		dial := dial
+		dials[i] = func() (redis.Conn, error) {
+			conn, err := dial()
+			if err == nil {
+				checkKeyspaceEvents(conn)
+			}
+			return conn, err
+		}
+	}
+	return dials
+}
+
+// checkKeyspaceEvents warns when the server does not send the keyspace
+// notifications watches rely on. Servers may forbid CONFIG, in which case
+// nothing is checked.
+func checkKeyspaceEvents(conn redis.Conn) {
+	values, err := redis.Strings(conn.Do("CONFIG", "GET", "notify-keyspace-events"))
+	if err != nil || len(values) != 2 {
+		return
+	}
+	flags := values[1]
+	if !strings.Contains(flags, "K") || !strings.ContainsAny(flags, "A$g") {
+		log.Warning("Redis keyspace notifications are disabled (notify-keyspace-events is %q), "+
+			"changes will not be noticed. Enable them with \"CONFIG SET notify-keyspace-events KA\" "+
+			"or set the watch_channel backend option", flags)
+	}
 }
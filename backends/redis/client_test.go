@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestGetValues(t *testing.T) {
+	c, s := newTestClient(t, map[string]value{
+		"/app/name":       {"string", []string{"web"}},
+		"/app/database":   {"hash", []string{"host", "127.0.0.1", "port", "3306"}},
+		"/app/upstreams":  {"list", []string{"10.0.1.10:8080", "10.0.1.11:8080"}},
+		"/app/features":   {"set", []string{"search"}},
+		"/app/nested/key": {"string", []string{"value"}},
+		"/other":          {"hash", []string{"ignored", "true"}},
+	})
+	defer s.Close()
+
+	vars, err := c.GetValues([]string{"/app", "/app/database"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/app/name":            "web",
+		"/app/database/host":   "127.0.0.1",
+		"/app/database/port":   "3306",
+		"/app/upstreams/0":     "10.0.1.10:8080",
+		"/app/upstreams/1":     "10.0.1.11:8080",
+		"/app/features/search": "search",
+		"/app/nested/key":      "value",
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+}
+
+func TestSentinelAndDatabase(t *testing.T) {
+	master := newFakeServer(t)
+	defer master.Close()
+	master.data["/app/name"] = value{"string", []string{"web"}}
+	sentinel := newFakeServer(t)
+	defer sentinel.Close()
+	sentinel.master = master.addr()
+
+	c, err := NewRedisClient([]string{"127.0.0.1:1", sentinel.addr()}, "secret", "", "", "",
+		Options{SentinelMaster: "mymaster", SentinelPassword: "sentinel-secret", Database: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["/app/name"] != "web" {
+		t.Errorf("GetValues() = %v, want the keys of the master", vars)
+	}
+	if want := []string{"AUTH sentinel-secret"}; !reflect.DeepEqual(sentinel.commands, want) {
+		t.Errorf("sentinel commands = %v, want %v", sentinel.commands, want)
+	}
+	if want := []string{"AUTH secret", "SELECT 3"}; !reflect.DeepEqual(master.commands, want) {
+		t.Errorf("master commands = %v, want %v", master.commands, want)
+	}
+
+	if _, err := NewRedisClient([]string{sentinel.addr()}, "", "", "", "", Options{SentinelMaster: "other"}); err == nil {
+		t.Error("NewRedisClient() succeeded with an unknown sentinel master")
+	}
+}
+
+// slotsReply returns a CLUSTER SLOTS reply giving the slots up to 8191 to
+// first and the others to second.
+func slotsReply(first, second string) string {
+	entry := func(start, end int, address string) string {
+		host, port, _ := net.SplitHostPort(address)
+		return fmt.Sprintf("*3\r\n:%d\r\n:%d\r\n*3\r\n%s:%s\r\n%s", start, end, bulk(host), port, bulk("id"))
+	}
+	return "*2\r\n" + entry(0, 8191, first) + entry(8192, slotCount-1, second)
+}
+
+func TestCluster(t *testing.T) {
+	first := newFakeServer(t)
+	defer first.Close()
+	second := newFakeServer(t)
+	defer second.Close()
+	first.slots = slotsReply(first.addr(), second.addr())
+
+	// The slots of the keys decide which master holds them.
+	for _, key := range []string{"/app/a", "/app/b", "/app/c", "/app/d"} {
+		if slot(key) < 8192 {
+			first.data[key] = value{"string", []string{key}}
+		} else {
+			second.data[key] = value{"string", []string{key}}
+		}
+	}
+	if len(first.data) == 0 || len(second.data) == 0 {
+		t.Fatal("the test keys all hash to the same master")
+	}
+
+	c, err := NewRedisClient([]string{first.addr()}, "", "", "", "", Options{Cluster: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := c.GetValues([]string{"/app", "/app/d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"/app/a": "/app/a", "/app/b": "/app/b", "/app/c": "/app/c", "/app/d": "/app/d"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+
+	if _, err := NewRedisClient([]string{first.addr()}, "", "", "", "", Options{Cluster: true, Database: 1}); err == nil {
+		t.Error("NewRedisClient() accepted a database for a cluster")
+	}
+}
+
+func TestSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 12739},
+		{"foo", 12182},
+		{"{foo}/bar", 12182},
+		{"{}foo", int(crc16("{}foo")) % slotCount},
+	}
+	for _, tt := range tests {
+		if got := slot(tt.key); got != tt.slot {
+			t.Errorf("slot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package redis
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// slotCount is the number of hash slots of a Redis Cluster.
+const slotCount = 16384
+
+// slotRange is a range of hash slots served by a master.
+type slotRange struct {
+	start, end int
+	address    string
+}
+
+// cluster routes the commands of a client to the masters of a Redis
+// Cluster. Its methods are called with the lock of the client held.
+type cluster struct {
+	client *Client
+	slots  []slotRange
+	conns  map[string]redis.Conn
+}
+
+// refresh loads the slots from the first seed node that answers.
+func (c *cluster) refresh() error {
+	var err error
+	for _, address := range c.client.machines {
+		var conn redis.Conn
+		conn, err = c.client.dial(address, c.client.password, 0, time.Second)
+		if err != nil {
+			continue
+		}
+		var slots []slotRange
+		slots, err = clusterSlots(conn)
+		conn.Close()
+		if err != nil {
+			continue
+		}
+		c.slots = slots
+		return nil
+	}
+	return err
+}
+
+// clusterSlots parses the reply of CLUSTER SLOTS, whose entries hold the
+// first and last slot of a range followed by the master and its replicas.
+func clusterSlots(conn redis.Conn) ([]slotRange, error) {
+	entries, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+	var slots []slotRange
+	for _, entry := range entries {
+		fields, err := redis.Values(entry, nil)
+		if err != nil || len(fields) < 3 {
+			return nil, errors.New("unexpected CLUSTER SLOTS reply")
+		}
+		start, err := redis.Int(fields[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		end, err := redis.Int(fields[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		master, err := redis.Values(fields[2], nil)
+		if err != nil || len(master) < 2 {
+			return nil, errors.New("unexpected CLUSTER SLOTS reply")
+		}
+		host, err := redis.String(master[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		port, err := redis.Int(master[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, slotRange{start, end, net.JoinHostPort(host, strconv.Itoa(port))})
+	}
+	if len(slots) == 0 {
+		return nil, errors.New("the redis cluster serves no slots")
+	}
+	return slots, nil
+}
+
+// reset closes the connections and forgets the slots, which are loaded
+// again by the next command.
+func (c *cluster) reset() {
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	c.conns = make(map[string]redis.Conn)
+	c.slots = nil
+}
+
+// masters returns the addresses of the masters.
+func (c *cluster) masters() ([]string, error) {
+	if c.slots == nil {
+		if err := c.refresh(); err != nil {
+			return nil, err
+		}
+	}
+	seen := make(map[string]bool)
+	var masters []string
+	for _, r := range c.slots {
+		if !seen[r.address] {
+			seen[r.address] = true
+			masters = append(masters, r.address)
+		}
+	}
+	sort.Strings(masters)
+	return masters, nil
+}
+
+// conn returns a connection to the master at address.
+func (c *cluster) conn(address string) (redis.Conn, error) {
+	if conn, ok := c.conns[address]; ok {
+		return conn, nil
+	}
+	conn, err := c.client.dial(address, c.client.password, 0, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[address] = conn
+	return conn, nil
+}
+
+// connFor returns a connection to the master serving the slot of key.
+func (c *cluster) connFor(key string) (redis.Conn, error) {
+	s := slot(key)
+	for _, r := range c.slots {
+		if s >= r.start && s <= r.end {
+			return c.conn(r.address)
+		}
+	}
+	return nil, errors.New("no redis cluster master serves the slot of " + key)
+}
+
+// getValues reads keys from the masters serving them and scans every
+// master for the keys below them.
+func (c *cluster) getValues(keys []string) (map[string]string, error) {
+	masters, err := c.masters()
+	if err != nil {
+		return nil, err
+	}
+	var conns []redis.Conn
+	for _, address := range masters {
+		conn, err := c.conn(address)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return getValues(keys, c.connFor, conns)
+}
+
+// slot returns the hash slot of key. Only the part between the first {
+// and the next } is hashed, if it is not empty.
+func slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % slotCount
+}
+
+// crc16 is the CRC-16/XMODEM checksum Redis Cluster hashes keys with.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
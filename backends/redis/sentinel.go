@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// sentinelMaster asks the sentinels in turn for the address of the master.
+func (c *Client) sentinelMaster() (string, error) {
+	var err error
+	for _, address := range c.machines {
+		var conn redis.Conn
+		conn, err = c.dial(address, c.opts.SentinelPassword, 0, time.Second)
+		if err != nil {
+			continue
+		}
+		var reply []string
+		reply, err = redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", c.opts.SentinelMaster))
+		conn.Close()
+		if err == redis.ErrNil || (err == nil && len(reply) != 2) {
+			err = fmt.Errorf("sentinel %s does not know master %s", address, c.opts.SentinelMaster)
+		}
+		if err != nil {
+			continue
+		}
+		return net.JoinHostPort(reply[0], reply[1]), nil
+	}
+	return "", err
+}
+
+// connectMaster connects to the master the sentinels point to. During a
+// failover a sentinel may still point to the demoted master, so the role
+// of the node is checked.
+func (c *Client) connectMaster(readTimeout time.Duration) (redis.Conn, error) {
+	address, err := c.sentinelMaster()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.dial(address, c.password, c.opts.Database, readTimeout)
+	if err != nil {
+		return nil, err
+	}
+	role, err := redis.Values(conn.Do("ROLE"))
+	if err == nil && len(role) > 0 {
+		var kind string
+		kind, err = redis.String(role[0], nil)
+		if err == nil && kind != "master" {
+			err = fmt.Errorf("redis node %s is a %s, not the master", address, kind)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,197 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// value is a key of the fake server: its type and the elements read by the
+// command of the type, the fields and values of a hash in turn.
+type value struct {
+	kind     string
+	elements []string
+}
+
+// fakeServer speaks just enough of the redis protocol for the client: the
+// commands reading strings, hashes, lists and sets, SCAN, and for watches
+// CONFIG GET, PSUBSCRIBE and PING in subscribed mode.
+type fakeServer struct {
+	ln         net.Listener
+	subscribed chan string
+
+	mu    sync.Mutex
+	conns []net.Conn
+	data  map[string]value
+	// master is the address a sentinel gives for master "mymaster".
+	master string
+	// slots is the raw reply to CLUSTER SLOTS.
+	slots string
+	// commands records the AUTH and SELECT commands.
+	commands []string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, subscribed: make(chan string, 16), data: make(map[string]value)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// newTestClient starts a fake server holding data and returns a client of
+// it.
+func newTestClient(t *testing.T, data map[string]value) (*Client, *fakeServer) {
+	s := newFakeServer(t)
+	for key, v := range data {
+		s.data[key] = v
+	}
+	c, err := NewRedisClient([]string{s.addr()}, "", "", "", "", Options{})
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return c, s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		s.mu.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "AUTH", "SELECT":
+			s.commands = append(s.commands, strings.Join(args, " "))
+			fmt.Fprint(conn, "+OK\r\n")
+		case "SENTINEL":
+			if host, port, err := net.SplitHostPort(s.master); err == nil && args[2] == "mymaster" {
+				fmt.Fprint(conn, bulks(host, port))
+			} else {
+				fmt.Fprint(conn, "*-1\r\n")
+			}
+		case "ROLE":
+			fmt.Fprint(conn, "*1\r\n"+bulk("master"))
+		case "CLUSTER":
+			fmt.Fprint(conn, s.slots)
+		case "CONFIG":
+			fmt.Fprint(conn, bulks("notify-keyspace-events", "K$g"))
+		case "PSUBSCRIBE":
+			for i, pattern := range args[1:] {
+				fmt.Fprintf(conn, "*3\r\n%s%s:%d\r\n", bulk("psubscribe"), bulk(pattern), i+1)
+				s.subscribed <- pattern
+			}
+		case "PING":
+			if len(args) > 1 {
+				fmt.Fprint(conn, bulks("pong", ""))
+			} else {
+				fmt.Fprint(conn, "+PONG\r\n")
+			}
+		case "TYPE":
+			if v, ok := s.data[args[1]]; ok {
+				fmt.Fprintf(conn, "+%s\r\n", v.kind)
+			} else {
+				fmt.Fprint(conn, "+none\r\n")
+			}
+		case "GET":
+			fmt.Fprint(conn, bulk(s.data[args[1]].elements[0]))
+		case "HGETALL", "LRANGE", "SMEMBERS":
+			fmt.Fprint(conn, bulks(s.data[args[1]].elements...))
+		case "SCAN":
+			// Everything is returned at once, cursor 0 ends the scan.
+			var keys []string
+			for key := range s.data {
+				// Only trailing wildcards are used, they match across
+				// slashes like redis globs.
+				if strings.HasPrefix(key, strings.TrimSuffix(args[3], "*")) {
+					keys = append(keys, key)
+				}
+			}
+			fmt.Fprintf(conn, "*2\r\n%s%s", bulk("0"), bulks(keys...))
+		}
+		s.mu.Unlock()
+	}
+}
+
+// publish sends a keyspace notification for key to all the clients.
+func (s *fakeServer) publish(pattern, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		fmt.Fprint(conn, bulks("pmessage", pattern, "__keyspace@0__:"+key, "set"))
+	}
+}
+
+// drop closes the connections of all the clients.
+func (s *fakeServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeServer) Close() {
+	s.ln.Close()
+	s.drop()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func bulks(values ...string) string {
+	out := fmt.Sprintf("*%d\r\n", len(values))
+	for _, v := range values {
+		out += bulk(v)
+	}
+	return out
+}
@@ -0,0 +1,204 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/kelseyhightower/confd/backends/changes"
+	"github.com/kelseyhightower/confd/log"
+)
+
+const (
+	// pingInterval is how often an idle subscription is checked.
+	pingInterval = 30 * time.Second
+	// maxReconnectDelay caps the delay between two subscription attempts.
+	maxReconnectDelay = 30 * time.Second
+	// subscribeTimeout bounds the wait for a subscription to be confirmed.
+	subscribeTimeout = 5 * time.Second
+)
+
+// watcher turns keyspace notifications, or the messages of a pub/sub
+// channel, into an increasing index. It holds a subscription per dial
+// function, all sharing the index.
+type watcher struct {
+	*changes.Log
+	dials []func() (redis.Conn, error)
+	// channel is the pub/sub channel to subscribe to, if keyspace
+	// notifications are not used.
+	channel string
+	db      int
+	// started makes sure there is a subscription before connecting, a
+	// connection outside of subscribed mode cannot be pinged.
+	started sync.Once
+
+	mu       sync.Mutex
+	conns    map[int]*redis.PubSubConn
+	patterns map[string]chan struct{}
+}
+
+func newWatcher(dials []func() (redis.Conn, error), channel string, db int) *watcher {
+	w := &watcher{
+		Log:      changes.NewLog(),
+		dials:    dials,
+		channel:  channel,
+		db:       db,
+		conns:    make(map[int]*redis.PubSubConn),
+		patterns: make(map[string]chan struct{}),
+	}
+	if channel != "" {
+		w.start()
+	}
+	return w
+}
+
+func (w *watcher) start() {
+	w.started.Do(func() {
+		for i := range w.dials {
+			go w.run(i)
+		}
+	})
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// pattern returns the keyspace notification pattern of the keys below
+// prefix.
+func (w *watcher) pattern(prefix string) string {
+	return fmt.Sprintf("__keyspace@%d__:%s*", w.db, globEscaper.Replace(prefix))
+}
+
+// run keeps subscription i alive, reconnecting when it drops.
+func (w *watcher) run(i int) {
+	delay := time.Second
+	for {
+		start := time.Now()
+		err := w.subscribe(i)
+		log.Error("Redis subscription failed, reconnecting: %s", err)
+		if time.Since(start) > maxReconnectDelay {
+			delay = time.Second
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// subscribe makes subscription i: it connects, subscribes to the channel
+// or to the patterns of all watched prefixes, and dispatches events until
+// the connection fails.
+func (w *watcher) subscribe(i int) error {
+	conn, err := w.dials[i]()
+	if err != nil {
+		return err
+	}
+	psc := &redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+
+	w.mu.Lock()
+	if w.channel != "" {
+		err = psc.Subscribe(w.channel)
+	} else {
+		var patterns []interface{}
+		for pattern := range w.patterns {
+			patterns = append(patterns, pattern)
+		}
+		err = psc.PSubscribe(patterns...)
+	}
+	w.conns[i] = psc
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.conns, i)
+		w.mu.Unlock()
+	}()
+	if err != nil {
+		return err
+	}
+
+	// Changes made while disconnected are unknown, wake up every watch.
+	w.Notify("")
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				w.mu.Lock()
+				err := psc.Ping("")
+				w.mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		switch m := psc.Receive().(type) {
+		case error:
+			return m
+		case redis.Subscription:
+			if m.Kind == "psubscribe" {
+				w.mu.Lock()
+				if confirmed, ok := w.patterns[m.Channel]; ok {
+					select {
+					case <-confirmed:
+					default:
+						close(confirmed)
+					}
+				}
+				w.mu.Unlock()
+			}
+		case redis.PMessage:
+			w.Notify(strings.TrimPrefix(m.Channel, fmt.Sprintf("__keyspace@%d__:", w.db)))
+		case redis.Message:
+			// Messages naming a key only wake up the watches of that key.
+			key := string(m.Data)
+			if !strings.HasPrefix(key, "/") {
+				key = ""
+			}
+			w.Notify(key)
+		}
+	}
+}
+
+// watch makes sure the keys below prefix are subscribed to.
+func (w *watcher) watch(prefix string) {
+	if w.channel != "" {
+		return
+	}
+	pattern := w.pattern(prefix)
+	w.mu.Lock()
+	confirmed, ok := w.patterns[pattern]
+	if !ok {
+		confirmed = make(chan struct{})
+		w.patterns[pattern] = confirmed
+		for _, conn := range w.conns {
+			if err := conn.PSubscribe(pattern); err != nil {
+				log.Error("Cannot subscribe to %s: %s", pattern, err)
+			}
+		}
+	}
+	connected := len(w.conns) > 0
+	w.mu.Unlock()
+	w.start()
+
+	if !connected {
+		// The pattern is subscribed to when the connection is back.
+		return
+	}
+	select {
+	case <-confirmed:
+	case <-time.After(subscribeTimeout):
+		log.Warning("Subscription to %s not confirmed after %s", pattern, subscribeTimeout)
+	}
+}
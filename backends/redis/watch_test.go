@@ -0,0 +1,89 @@
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+// watchAsync runs WatchPrefix in the background and returns its result.
+func watchAsync(c *Client, prefix string, keys []string, waitIndex uint64, stopChan chan bool) chan uint64 {
+	done := make(chan uint64, 1)
+	go func() {
+		index, _ := c.WatchPrefix(prefix, keys, waitIndex, stopChan)
+		done <- index
+	}()
+	return done
+}
+
+func waitIndex(t *testing.T, done chan uint64, after uint64) uint64 {
+	select {
+	case index := <-done:
+		if index <= after {
+			t.Fatalf("WatchPrefix() = %d, want an index after %d", index, after)
+		}
+		return index
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchPrefix did not return")
+	}
+	return 0
+}
+
+func TestWatchPrefix(t *testing.T) {
+	c, s := newTestClient(t, nil)
+	defer s.Close()
+	stopChan := make(chan bool)
+	defer close(stopChan)
+
+	index, err := c.WatchPrefix("/app", []string{"/app/db"}, 0, stopChan)
+	if err != nil || index == 0 {
+		t.Fatalf("WatchPrefix() = %d, %v, want an index right away", index, err)
+	}
+
+	// The first subscription wakes up the watch, changes made before it
+	// are unknown.
+	done := watchAsync(c, "/app", []string{"/app/db"}, index, stopChan)
+	pattern := <-s.subscribed
+	if pattern != "__keyspace@0__:/app*" {
+		t.Errorf("pattern = %q, want __keyspace@0__:/app*", pattern)
+	}
+	index = waitIndex(t, done, index)
+
+	done = watchAsync(c, "/app", []string{"/app/db"}, index, stopChan)
+	s.publish(pattern, "/app/other")
+	select {
+	case <-done:
+		t.Fatal("WatchPrefix returned for a key that is not watched")
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.publish(pattern, "/app/db/host")
+	index = waitIndex(t, done, index)
+
+	// A change between two watches is not missed.
+	s.publish(pattern, "/app/db/port")
+	time.Sleep(50 * time.Millisecond)
+	index = waitIndex(t, watchAsync(c, "/app", []string{"/app/db"}, index, stopChan), index)
+
+	// The subscription is made again after the connection drops.
+	done = watchAsync(c, "/app", []string{"/app/db"}, index, stopChan)
+	s.drop()
+	if pattern := <-s.subscribed; pattern != "__keyspace@0__:/app*" {
+		t.Errorf("pattern after reconnecting = %q, want __keyspace@0__:/app*", pattern)
+	}
+	waitIndex(t, done, index)
+}
+
+func TestWatchPrefixStop(t *testing.T) {
+	c, s := newTestClient(t, nil)
+	defer s.Close()
+	stopChan := make(chan bool)
+	done := watchAsync(c, "/app", []string{"/app"}, 100, stopChan)
+	close(stopChan)
+	select {
+	case index := <-done:
+		if index != 100 {
+			t.Errorf("WatchPrefix() = %d, want the unchanged index 100", index)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WatchPrefix did not return when stopped")
+	}
+}
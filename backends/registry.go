@@ -0,0 +1,87 @@
+package backends
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// A Factory creates the StoreClient of a backend from the configuration.
+type Factory func(config Config) (StoreClient, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a backend available by name. Packages providing a backend
+// call it from their init function:
+//
+//	func init() {
+//		backends.Register("mystore", func(config backends.Config) (backends.StoreClient, error) {
+//			var opts Options
+//			if err := config.DecodeOptions(&opts); err != nil {
+//				return nil, err
+//			}
+//			return NewMyStoreClient(config.BackendNodes, opts)
+//		})
+//	}
+//
+// Register panics if factory is nil or if name is already registered.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("backends: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("backends: Register called twice for backend " + name)
+	}
+	factories[name] = factory
+}
+
+// Backends returns the sorted names of the registered backends.
+func Backends() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookup(name string) (Factory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// DecodeOptions decodes the backend options into v, which must be a pointer
+// to a struct. Fields are matched by their `mapstructure` tag, or else by
+// their name, ignoring case. Strings, as given with -backend-option, are
+// converted to the field types: comma separated lists become slices and
+// durations are parsed with time.ParseDuration. Options that no field
+// matches are reported as errors.
+func (c Config) DecodeOptions(v interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToSliceHookFunc(","),
+			mapstructure.StringToTimeDurationHookFunc(),
+		),
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           v,
+	})
+	if err != nil {
+		return err
+	}
+	if err := decoder.Decode(c.Options); err != nil {
+		return fmt.Errorf("invalid %s backend options: %s", c.Backend, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package backends
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegister(t *testing.T) {
+	Register("test", func(config Config) (StoreClient, error) {
+		return nil, errors.New("test backend")
+	})
+	defer func() {
+		factoriesMu.Lock()
+		delete(factories, "test")
+		factoriesMu.Unlock()
+	}()
+
+	if _, err := New(Config{Backend: "test"}); err == nil || err.Error() != "test backend" {
+		t.Error("New() did not use the registered factory")
+	}
+	if _, err := New(Config{Backend: "unknown"}); err == nil || err.Error() != "Invalid backend" {
+		t.Errorf("New(unknown) error = %v, want Invalid backend", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a backend twice did not panic")
+		}
+	}()
+	Register("test", func(config Config) (StoreClient, error) { return nil, nil })
+}
+
+func TestDecodeOptions(t *testing.T) {
+	type options struct {
+		Region  string        `mapstructure:"region"`
+		Retries int           `mapstructure:"retries"`
+		Timeout time.Duration `mapstructure:"timeout"`
+		Tags    []string      `mapstructure:"tags"`
+	}
+	config := Config{Backend: "test", Options: map[string]interface{}{
+		"region":  "eu-west-1",
+		"retries": "3",
+		"timeout": "1m30s",
+		"tags":    "a,b",
+	}}
+
+	var opts options
+	if err := config.DecodeOptions(&opts); err != nil {
+		t.Fatal(err)
+	}
+	want := options{"eu-west-1", 3, 90 * time.Second, []string{"a", "b"}}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("DecodeOptions() = %+v, want %+v", opts, want)
+	}
+
+	config.Options["unknown"] = "x"
+	if err := config.DecodeOptions(&opts); err == nil {
+		t.Error("DecodeOptions() accepted an unknown option")
+	}
+
+	if err := (Config{}).DecodeOptions(&opts); err != nil {
+		t.Errorf("DecodeOptions() without options = %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/changes"
+	"github.com/kelseyhightower/confd/log"
+)
+
+const (
+	// pollInterval is how often the version column is checked for changes.
+	pollInterval = time.Second
+	// notifyPollInterval is the safety-net polling interval used when change
+	// notifications are available.
+	notifyPollInterval = 30 * time.Second
+)
+
+// rebuild tells how to compile in each supported driver. The sqlite3
+// driver is built in whenever cgo is enabled, the others need a build tag.
+var rebuild = map[string]string{
+	"sqlite3":  "CGO_ENABLED=1",
+	"postgres": "-tags postgres",
+	"mysql":    "-tags mysql",
+}
+
+// listeners holds the change notification implementations by driver name.
+// Drivers that support notifications register themselves from files built
+// with the matching build tag.
+var listeners = map[string]func(dsn, channel string, onNotify func()) (io.Closer, error){}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// Options are the sql specific backend options.
+type Options struct {
+	// Driver is the database/sql driver: sqlite3, postgres or mysql.
+	Driver string `mapstructure:"driver"`
+	// KeyColumn, ValueColumn and VersionColumn name the columns holding
+	// the keys, the values and the timestamp or version polled for
+	// changes.
+	KeyColumn     string `mapstructure:"key_column"`
+	ValueColumn   string `mapstructure:"value_column"`
+	VersionColumn string `mapstructure:"version_column"`
+	// NotifyChannel is the PostgreSQL channel to LISTEN on for changes, in
+	// addition to polling.
+	NotifyChannel string `mapstructure:"notify_channel"`
+}
+
+// Client reads key/value pairs from a relational table.
+type Client struct {
+	db       *sql.DB
+	table    string
+	opts     Options
+	listener io.Closer
+
+	mu      sync.Mutex
+	changed chan struct{}
+	// versions holds the tableVersion of the indexes returned by
+	// WatchPrefix.
+	versions *changes.States
+}
+
+// tableVersion identifies the state of the rows below a prefix.
+type tableVersion struct {
+	prefix  string
+	count   int64
+	version uint64
+}
+
+func init() {
+	backends.Register("sql", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return NewSQLClient(config.BackendNodes[0], config.Table, opts)
+	})
+}
+
+// NewSQLClient opens the database of data source name dsn and checks that
+// table and the configured columns exist.
+func NewSQLClient(dsn, table string, opts Options) (*Client, error) {
+	if table == "" {
+		table = "config"
+	}
+	if opts.KeyColumn == "" {
+		opts.KeyColumn = "key"
+	}
+	if opts.ValueColumn == "" {
+		opts.ValueColumn = "value"
+	}
+	if opts.VersionColumn == "" {
+		opts.VersionColumn = "updated_at"
+	}
+	for _, name := range []string{table, opts.KeyColumn, opts.ValueColumn, opts.VersionColumn} {
+		if !identifier.MatchString(name) {
+			return nil, fmt.Errorf("invalid table or column name %q", name)
+		}
+	}
+	if opts.Driver == "" {
+		return nil, errors.New("no SQL driver configured, set it with -backend-option driver=<name>")
+	}
+	if !registered(opts.Driver) {
+		if how, ok := rebuild[opts.Driver]; ok {
+			return nil, fmt.Errorf("confd was built without the %s SQL driver, rebuild it with %s", opts.Driver, how)
+		}
+		return nil, fmt.Errorf("unknown SQL driver %q, confd was built with: %s", opts.Driver, strings.Join(sql.Drivers(), ", "))
+	}
+
+	db, err := sql.Open(opts.Driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		db:       db,
+		table:    table,
+		opts:     opts,
+		changed:  make(chan struct{}),
+		versions: changes.NewStates(),
+	}
+	if _, _, err := c.version("/"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if opts.NotifyChannel != "" {
+		listen, ok := listeners[opts.Driver]
+		if !ok {
+			db.Close()
+			return nil, fmt.Errorf("the %s driver does not support change notifications", opts.Driver)
+		}
+		log.Info("Listening for notifications on channel %s", opts.NotifyChannel)
+		c.listener, err = listen(dsn, opts.NotifyChannel, c.notify)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// registered reports whether the driver was compiled in.
+func registered(driver string) bool {
+	for _, name := range sql.Drivers() {
+		if name == driver {
+			return true
+		}
+	}
+	return false
+}
+
+// notify wakes up every pending watch.
+func (c *Client) notify() {
+	c.mu.Lock()
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mu.Unlock()
+}
+
+// placeholder returns the n-th bind parameter in the driver's syntax.
+func (c *Client) placeholder(n int) string {
+	if c.opts.Driver == "postgres" {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// quote quotes a possibly schema qualified identifier for the driver.
+func (c *Client) quote(name string) string {
+	q := `"`
+	if c.opts.Driver == "mysql" {
+		q = "`"
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = q + part + q
+	}
+	return strings.Join(parts, ".")
+}
+
+// likeEscaper escapes LIKE wildcards using '!' as the escape character,
+// which needs no quoting in any supported dialect.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// where returns the condition selecting key and all keys below it.
+func (c *Client) where(key string) (string, []interface{}) {
+	key = strings.TrimSuffix(key, "/")
+	column := c.quote(c.opts.KeyColumn)
+	cond := fmt.Sprintf("(%s = %s OR %s LIKE %s ESCAPE '!')",
+		column, c.placeholder(1), column, c.placeholder(2))
+	return cond, []interface{}{key, likeEscaper.Replace(key) + "/%"}
+}
+
+// GetValues queries the table for keys prefixed by prefix.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, key := range keys {
+		cond, args := c.where(key)
+		query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s",
+			c.quote(c.opts.KeyColumn), c.quote(c.opts.ValueColumn), c.quote(c.table), cond)
+		rows, err := c.db.Query(query, args...)
+		if err != nil {
+			return vars, err
+		}
+		for rows.Next() {
+			var k string
+			var v sql.NullString
+			if err := rows.Scan(&k, &v); err != nil {
+				rows.Close()
+				return vars, err
+			}
+			vars[k] = v.String
+		}
+		err = rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+		if err != nil {
+			return vars, err
+		}
+	}
+	return vars, nil
+}
+
+// version returns the number of rows below prefix and their newest
+// version.
+func (c *Client) version(prefix string) (int64, uint64, error) {
+	cond, args := c.where(prefix)
+	query := fmt.Sprintf("SELECT COUNT(*), MAX(%s) FROM %s WHERE %s",
+		c.quote(c.opts.VersionColumn), c.quote(c.table), cond)
+	var count int64
+	var max interface{}
+	if err := c.db.QueryRow(query, args...).Scan(&count, &max); err != nil {
+		return 0, 0, err
+	}
+	return count, toIndex(max), nil
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+// toIndex converts the value of a version column into a wait index.
+// Timestamps become nanoseconds since the epoch, anything else that is not
+// a number is hashed.
+func toIndex(v interface{}) uint64 {
+	switch t := v.(type) {
+	case nil:
+		return 0
+	case int64:
+		return uint64(t)
+	case float64:
+		return uint64(t)
+	case time.Time:
+		return uint64(t.UnixNano())
+	case []byte:
+		return toIndex(string(t))
+	case string:
+		if i, err := strconv.ParseUint(t, 10, 64); err == nil {
+			return i
+		}
+		for _, layout := range timeLayouts {
+			if ts, err := time.Parse(layout, t); err == nil {
+				return uint64(ts.UnixNano())
+			}
+		}
+		h := fnv.New64a()
+		h.Write([]byte(t))
+		return h.Sum64()
+	}
+	return toIndex(fmt.Sprint(v))
+}
+
+// index returns the index of the current state of the rows below a prefix,
+// their newest version unless it is not past waitIndex, and remembers the
+// state of the index.
+func (c *Client) index(current tableVersion, waitIndex uint64) uint64 {
+	index := current.version
+	if index <= waitIndex {
+		index = waitIndex + 1
+	}
+	return c.versions.Put(index, current)
+}
+
+// WatchPrefix polls the version column of the rows below prefix, and
+// returns as soon as their newest version or their number differs from the
+// ones of waitIndex.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	state, seen := c.versions.Get(waitIndex)
+	last, _ := state.(tableVersion)
+
+	interval := pollInterval
+	if c.listener != nil {
+		interval = notifyPollInterval
+	}
+
+	for {
+		// Take the notification channel before querying so that a change
+		// committed in between is not missed.
+		c.mu.Lock()
+		changed := c.changed
+		c.mu.Unlock()
+
+		count, version, err := c.version(prefix)
+		if err != nil {
+			return waitIndex, err
+		}
+		current := tableVersion{prefix, count, version}
+
+		// return something > 0 to trigger a key retrieval from the store.
+		// Without the state of waitIndex, the current one is returned.
+		if waitIndex == 0 || !seen || current != last {
+			return c.index(current, waitIndex), nil
+		}
+
+		select {
+		case <-stopChan:
+			return waitIndex, nil
+		case <-changed:
+		case <-time.After(interval):
+		}
+	}
+}
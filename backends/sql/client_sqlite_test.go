@@ -0,0 +1,90 @@
+// +build cgo
+
+package sql
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestClient(t *testing.T) (*Client, *sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "confd-sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsn := filepath.Join(dir, "config.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE settings (name TEXT PRIMARY KEY, value TEXT, version INTEGER);
+		INSERT INTO settings VALUES ('/app/db/host', '127.0.0.1', 1);
+		INSERT INTO settings VALUES ('/app/db/port', '3306', 2);
+		INSERT INTO settings VALUES ('/app_other/key', 'x', 3);
+		INSERT INTO settings VALUES ('/app', 'root', 4);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewSQLClient(dsn, "settings", Options{
+		Driver:        "sqlite3",
+		KeyColumn:     "name",
+		VersionColumn: "version",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetValues(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	got, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/app":         "root",
+		"/app/db/host": "127.0.0.1",
+		"/app/db/port": "3306",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	c, db, cleanup := newTestClient(t)
+	defer cleanup()
+
+	stopChan := make(chan bool)
+	index, err := c.WatchPrefix("/app", []string{"/app"}, 0, stopChan)
+	if err != nil || index != 4 {
+		t.Fatalf("WatchPrefix() = %d, %v, want 4, nil", index, err)
+	}
+
+	if _, err := db.Exec(`UPDATE settings SET value = 'db.example.com', version = 5 WHERE name = '/app/db/host'`); err != nil {
+		t.Fatal(err)
+	}
+	index, err = c.WatchPrefix("/app", []string{"/app"}, index, stopChan)
+	if err != nil || index != 5 {
+		t.Fatalf("WatchPrefix() = %d, %v, want 5, nil", index, err)
+	}
+
+	// A delete leaves the newest version in place but still counts.
+	if _, err := db.Exec(`DELETE FROM settings WHERE name = '/app/db/port'`); err != nil {
+		t.Fatal(err)
+	}
+	index, err = c.WatchPrefix("/app", []string{"/app"}, index, stopChan)
+	if err != nil || index != 6 {
+		t.Fatalf("WatchPrefix() after delete = %d, %v, want 6, nil", index, err)
+	}
+}
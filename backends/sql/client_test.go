@@ -0,0 +1,148 @@
+package sql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTable is the settings table served by the fake driver.
+type fakeTable struct {
+	mu   sync.Mutex
+	rows map[string]fakeRow
+}
+
+type fakeRow struct {
+	value   string
+	version int64
+}
+
+var settings = &fakeTable{rows: make(map[string]fakeRow)}
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+func (t *fakeTable) set(key, value string, version int64) {
+	t.mu.Lock()
+	t.rows[key] = fakeRow{value, version}
+	t.mu.Unlock()
+}
+
+func (t *fakeTable) remove(key string) {
+	t.mu.Lock()
+	delete(t.rows, key)
+	t.mu.Unlock()
+}
+
+// fakeDriver answers the two queries of the client, selecting rows the way
+// their WHERE clause does for keys without LIKE wildcards.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt(query), nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type fakeStmt string
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	key := args[0].(string)
+	below := strings.TrimSuffix(args[1].(string), "%")
+	settings.mu.Lock()
+	defer settings.mu.Unlock()
+	var rows [][]driver.Value
+	var count int64
+	var max driver.Value
+	for k, r := range settings.rows {
+		if k != key && !strings.HasPrefix(k, below) {
+			continue
+		}
+		rows = append(rows, []driver.Value{k, r.value})
+		count++
+		if v, ok := max.(int64); !ok || r.version > v {
+			max = r.version
+		}
+	}
+	if strings.HasPrefix(string(s), "SELECT COUNT(*)") {
+		rows = [][]driver.Value{{count, max}}
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"a", "b"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestWatchPrefixSharedByResources(t *testing.T) {
+	settings.set("/app/db/host", "127.0.0.1", 1)
+	settings.set("/app/db/port", "3306", 2)
+	c, err := NewSQLClient("", "settings", Options{Driver: "fake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopChan := make(chan bool)
+	defer time.AfterFunc(5*time.Second, func() { close(stopChan) }).Stop()
+	keys := []string{"/app"}
+	first, err := c.WatchPrefix("/app", keys, 0, stopChan)
+	if err != nil || first != 2 {
+		t.Fatalf("WatchPrefix() = %d, %v, want 2, nil", first, err)
+	}
+
+	// Two resources watch the same prefix, the second one must see the
+	// change the first one already returned.
+	settings.set("/app/db/host", "db.example.com", 3)
+	for _, resource := range []string{"a", "b"} {
+		index, err := c.WatchPrefix("/app", keys, first, stopChan)
+		if err != nil || index != 3 {
+			t.Fatalf("WatchPrefix() of resource %s = %d, %v, want 3, nil", resource, index, err)
+		}
+	}
+
+	// A delete leaves the newest version in place but still counts.
+	settings.remove("/app/db/port")
+	for _, resource := range []string{"a", "b"} {
+		index, err := c.WatchPrefix("/app", keys, 3, stopChan)
+		if err != nil || index != 4 {
+			t.Fatalf("WatchPrefix() of resource %s after delete = %d, %v, want 4, nil", resource, index, err)
+		}
+	}
+}
+
+func TestNewSQLClientWithoutDriver(t *testing.T) {
+	if registered("mysql") {
+		t.Skip("built with the mysql driver")
+	}
+	_, err := NewSQLClient("user@/confd", "", Options{Driver: "mysql"})
+	if err == nil || !strings.Contains(err.Error(), "-tags mysql") {
+		t.Errorf("NewSQLClient() error = %v, want a hint to rebuild with -tags mysql", err)
+	}
+}
@@ -0,0 +1,8 @@
+// +build mysql
+
+package sql
+
+import (
+	// Register the mysql driver.
+	_ "github.com/go-sql-driver/mysql"
+)
@@ -0,0 +1,42 @@
+// +build postgres
+
+package sql
+
+import (
+	"io"
+	"time"
+
+	"github.com/kelseyhightower/confd/log"
+	"github.com/lib/pq"
+)
+
+func init() {
+	listeners["postgres"] = listenPostgres
+}
+
+// listenPostgres issues LISTEN on channel and calls onNotify for every
+// NOTIFY, and whenever the connection is re-established since
+// notifications may have been missed in between.
+func listenPostgres(dsn, channel string, onNotify func()) (io.Closer, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Error("postgres listener: %s", err.Error())
+		}
+		if ev == pq.ListenerEventReconnected {
+			onNotify()
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	go func() {
+		for n := range listener.Notify {
+			if n != nil {
+				log.Debug("postgres notification on %s: %s", n.Channel, n.Extra)
+			}
+			onNotify()
+		}
+	}()
+	return listener, nil
+}
@@ -0,0 +1,8 @@
+// +build cgo
+
+package sql
+
+import (
+	// Register the sqlite3 driver.
+	_ "github.com/mattn/go-sqlite3"
+)
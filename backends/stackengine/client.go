@@ -1,16 +1,32 @@
 package stackengine
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 	"strings"
+
+	"github.com/kelseyhightower/confd/backends"
 )
 
+// maxWait is how long a blocking query is held by StackEngine before it
+// answers with the same index.
+const maxWait = "5m"
+
+// Options are the StackEngine specific settings, set as backend options.
+type Options struct {
+	// InsecureSkipVerify disables the verification of the certificate of
+	// StackEngine.
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
 // Client is an empty wrapper around the StackEngine client
 type Client struct {
 	client    *http.Client
@@ -19,12 +35,19 @@ type Client struct {
 	transport *http.Transport
 }
 
+func init() {
+	backends.Register("stackengine", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return NewStackEngineClient(config.BackendNodes, config.Scheme, config.ClientCert, config.ClientKey, config.ClientCaKeys, config.AuthToken, opts)
+	})
+}
+
 // NewStackEngineClient returns a client object with connection information.
-func NewStackEngineClient(nodes []string, scheme, cert, key, caCert string, authToken string) (*Client, error) {
-	var (
-		err  error
-		host string
-	)
+func NewStackEngineClient(nodes []string, scheme, cert, key, caCert string, authToken string, opts Options) (*Client, error) {
+	var host string
 
 	if len(nodes) > 0 {
 		host = nodes[0]
@@ -49,17 +72,20 @@ func NewStackEngineClient(nodes []string, scheme, cert, key, caCert string, auth
 			return nil, err
 		}
 		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(ca)
+		if !caCertPool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", caCert)
+		}
 		tlsConfig.RootCAs = caCertPool
 	}
-	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.InsecureSkipVerify = opts.InsecureSkipVerify
 	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
 		TLSClientConfig: tlsConfig,
 	}
 
 	client := &http.Client{Transport: transport}
 
-	return &Client{client, authToken, base, transport}, err
+	return &Client{client, authToken, base, transport}, nil
 }
 
 // KVPair is used to represent a single K/V entry
@@ -73,41 +99,60 @@ type KVPair struct {
 	Session     string
 }
 
-// GetValues queries StackEngine for keys prefixed by prefix.
-func (c *Client) GetValues(keys []string) (map[string]string, error) {
-	vars := make(map[string]string)
-	var pairs []KVPair
-
-	for _, key := range keys {
-		key := strings.TrimPrefix(key, "/")
-
-		uri := c.base + "/v1/kv/" + key + "?recurse"
-		req, err := http.NewRequest("GET", uri, nil)
-
-		bearer := "Bearer " + c.token
-
-		req.Header.Add("Authorization", bearer)
-		parseFormErr := req.ParseForm()
-		if parseFormErr != nil {
-			fmt.Println(parseFormErr)
-		}
+// list returns the pairs below key and the index of the KV store. With a
+// non-zero index, the query blocks until the store changes after index, or
+// until ctx is done.
+func (c *Client) list(ctx context.Context, key string, index uint64) ([]KVPair, uint64, error) {
+	query := url.Values{"recurse": {""}}
+	if index != 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", maxWait)
+	}
+	uri := c.base + "/v1/kv/" + strings.TrimPrefix(key, "/") + "?" + query.Encode()
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Add("Authorization", "Bearer "+c.token)
 
-		// Fetch Request
-		resp, err := c.client.Do(req)
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
 
-		if err != nil {
-			fmt.Println("Failure : ", err)
-			return nil, err
+	var pairs []KVPair
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+			return nil, 0, fmt.Errorf("cannot decode the keys of %s: %s", key, err)
 		}
+	case http.StatusNotFound:
+		// There is no key below key.
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, 0, fmt.Errorf("cannot read %s: %s: %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
 
-		// Read Response Body
-		respBody, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println("Error reading http response: ", err)
+	// The index of the store, or else the index of the last change of
+	// the pairs.
+	index, err = strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		index = 0
+		for _, p := range pairs {
+			if p.ModifyIndex > index {
+				index = p.ModifyIndex
+			}
 		}
+	}
+	return pairs, index, nil
+}
 
-		err = json.Unmarshal(respBody, &pairs)
-
+// GetValues queries StackEngine for keys prefixed by prefix.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, key := range keys {
+		pairs, _, err := c.list(context.Background(), key, 0)
 		if err != nil {
 			return vars, err
 		}
@@ -115,16 +160,36 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 			vars[path.Join("/", p.Key)] = string(p.Value)
 		}
 	}
-
 	return vars, nil
 }
 
-type watchResponse struct {
-	waitIndex uint64
-	err       error
-}
-
+// WatchPrefix blocks on the keys below prefix until they change after
+// waitIndex, and returns the new index.
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	<-stopChan
-	return 0, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		_, index, err := c.list(ctx, prefix, waitIndex)
+		if ctx.Err() != nil {
+			return waitIndex, nil
+		}
+		if err != nil {
+			return waitIndex, err
+		}
+		// return something > 0 to trigger a key retrieval from the store
+		if index == 0 {
+			index = 1
+		}
+		if index != waitIndex {
+			return index, nil
+		}
+	}
 }
@@ -0,0 +1,138 @@
+package stackengine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKV serves the pairs of a KV store, holding the blocking queries
+// until the store changes.
+type fakeKV struct {
+	mu      sync.Mutex
+	index   uint64
+	pairs   []KVPair
+	changed chan struct{}
+	auth    []string
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	index, changed := f.index, f.changed
+	f.mu.Unlock()
+	if wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); wait != 0 && wait >= index {
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	var pairs []KVPair
+	for _, p := range f.pairs {
+		if strings.HasPrefix(p.Key, prefix) {
+			pairs = append(pairs, p)
+		}
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	if len(pairs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(pairs)
+}
+
+func (f *fakeKV) put(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	f.pairs = append(f.pairs, KVPair{Key: key, Value: []byte(value), ModifyIndex: f.index})
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func newTestClient(t *testing.T, f *fakeKV) (*Client, func()) {
+	s := httptest.NewTLSServer(f)
+	node := strings.TrimPrefix(s.URL, "https://")
+	c, err := NewStackEngineClient([]string{node}, "https", "", "", "", "token", Options{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, s.Close
+}
+
+func TestGetValues(t *testing.T) {
+	f := &fakeKV{index: 2, changed: make(chan struct{}), pairs: []KVPair{
+		{Key: "app/a", Value: []byte("1"), ModifyIndex: 1},
+		{Key: "app/b", Value: []byte("2"), ModifyIndex: 2},
+	}}
+	c, stop := newTestClient(t, f)
+	defer stop()
+
+	vars, err := c.GetValues([]string{"/app", "/missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"/app/a": "1", "/app/b": "2"}; !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+	if f.auth[0] != "Bearer token" {
+		t.Errorf("Authorization = %q, want the bearer token", f.auth[0])
+	}
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	s := httptest.NewTLSServer(&fakeKV{changed: make(chan struct{})})
+	defer s.Close()
+	c, err := NewStackEngineClient([]string{strings.TrimPrefix(s.URL, "https://")}, "https", "", "", "", "token", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetValues([]string{"/app"}); err == nil {
+		t.Error("GetValues() accepted a self-signed certificate")
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	f := &fakeKV{index: 1, changed: make(chan struct{}), pairs: []KVPair{
+		{Key: "app/a", Value: []byte("1"), ModifyIndex: 1},
+	}}
+	c, stop := newTestClient(t, f)
+	defer stop()
+	stopChan := make(chan bool)
+
+	index, err := c.WatchPrefix("/app", []string{"/app"}, 0, stopChan)
+	if err != nil || index != 1 {
+		t.Fatalf("WatchPrefix() = %d, %v, want 1", index, err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		f.put("app/b", "2")
+	}()
+	start := time.Now()
+	if index, err = c.WatchPrefix("/app", []string{"/app"}, index, stopChan); err != nil || index != 2 {
+		t.Fatalf("WatchPrefix() = %d, %v, want 2", index, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("WatchPrefix() returned after %s, before the change", elapsed)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(stopChan)
+	}()
+	if next, err := c.WatchPrefix("/app", []string{"/app"}, index, stopChan); err != nil || next != index {
+		t.Errorf("WatchPrefix() = %d, %v, want %d once stopped", next, err, index)
+	}
+}
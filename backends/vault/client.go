@@ -8,15 +8,52 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"path"
+	"strings"
+	"sync"
+	"time"
 
 	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/flatten"
 	"github.com/kelseyhightower/confd/log"
 )
 
+// Options are the settings of the approle, kubernetes and cert auth
+// methods, set as backend options.
+type Options struct {
+	// AuthMount is the path the auth method is enabled at, which defaults
+	// to the name of the method.
+	AuthMount string `mapstructure:"auth_mount"`
+	// RoleID and SecretID log in with the approle method. SecretIDFile is
+	// read at every login instead of SecretID, if set.
+	RoleID       string `mapstructure:"role_id"`
+	SecretID     string `mapstructure:"secret_id"`
+	SecretIDFile string `mapstructure:"secret_id_file"`
+	// Role is the role of the kubernetes method, and the name of the
+	// certificate role of the cert method.
+	Role string `mapstructure:"role"`
+	// ServiceAccountTokenFile holds the JWT of the kubernetes method.
+	ServiceAccountTokenFile string `mapstructure:"service_account_token_file"`
+}
+
+// defaultServiceAccountTokenFile is where pods find their service account
+// token.
+const defaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
 // Client is a wrapper around the vault client
 type Client struct {
-	client *vaultapi.Client
+	client   *vaultapi.Client
+	authType string
+	params   map[string]string
+	opts     Options
+
+	// authMu is held to change the token, and while reading with it.
+	authMu sync.RWMutex
+
+	mu         sync.Mutex
+	mounts     []*mount
+	leases     map[string]*lease
+	leaseIndex uint64
 }
 
 // get a
@@ -29,6 +66,15 @@ func getParameter(key string, parameters map[string]string) string {
 	return value
 }
 
+// getOption returns value, the value of backend option key.
+func getOption(key, value string) string {
+	if value == "" {
+		// panic if a configuration is missing
+		panic(fmt.Sprintf("%s is missing from the backend options", key))
+	}
+	return value
+}
+
 // panicToError converts a panic to an error
 func panicToError(err *error) {
 	if r := recover(); r != nil {
@@ -43,8 +89,27 @@ func panicToError(err *error) {
 	}
 }
 
-// authenticate with the remote client
-func authenticate(c *vaultapi.Client, authType string, params map[string]string) (err error) {
+// loginPath returns the login path of auth method authType.
+func loginPath(authType string, opts Options) string {
+	mount := opts.AuthMount
+	if mount == "" {
+		mount = authType
+	}
+	return fmt.Sprintf("/auth/%s/login", strings.Trim(mount, "/"))
+}
+
+// readFile returns the trimmed content of file.
+func readFile(file string) string {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// authenticate with the remote client. It returns the TTL of the token, 0
+// if it does not expire, and whether it can be renewed.
+func authenticate(c *vaultapi.Client, authType string, params map[string]string, opts Options) (ttl time.Duration, renewable bool, err error) {
 	var secret *vaultapi.Secret
 
 	// handle panics gracefully by creating an error
@@ -57,34 +122,80 @@ func authenticate(c *vaultapi.Client, authType string, params map[string]string)
 			"app_id":  getParameter("app-id", params),
 			"user_id": getParameter("user-id", params),
 		})
+	case "approle":
+		data := map[string]interface{}{
+			"role_id": getOption("role_id", opts.RoleID),
+		}
+		if opts.SecretIDFile != "" {
+			data["secret_id"] = readFile(opts.SecretIDFile)
+		} else if opts.SecretID != "" {
+			data["secret_id"] = opts.SecretID
+		}
+		secret, err = c.Logical().Write(loginPath(authType, opts), data)
+	case "cert":
+		// The client certificate of the TLS connection is the credential.
+		getParameter("cert", params)
+		data := map[string]interface{}{}
+		if opts.Role != "" {
+			data["name"] = opts.Role
+		}
+		secret, err = c.Logical().Write(loginPath(authType, opts), data)
 	case "github":
 		secret, err = c.Logical().Write("/auth/github/login", map[string]interface{}{
 			"token": getParameter("token", params),
 		})
+	case "kubernetes":
+		file := opts.ServiceAccountTokenFile
+		if file == "" {
+			file = defaultServiceAccountTokenFile
+		}
+		secret, err = c.Logical().Write(loginPath(authType, opts), map[string]interface{}{
+			"role": getOption("role", opts.Role),
+			"jwt":  readFile(file),
+		})
 	case "token":
 		c.SetToken(getParameter("token", params))
 		secret, err = c.Logical().Read("/auth/token/lookup-self")
+		if err != nil {
+			return 0, false, err
+		}
+		if secret == nil {
+			return 0, false, errors.New("the vault token cannot be looked up")
+		}
+		// The token has no auth section, its lookup tells about it.
+		renewable, _ = secret.Data["renewable"].(bool)
+		return seconds(secret.Data["ttl"]), renewable, nil
 	case "userpass":
 		username, password := getParameter("username", params), getParameter("password", params)
 		secret, err = c.Logical().Write(fmt.Sprintf("/auth/userpass/login/%s", username), map[string]interface{}{
 			"password": password,
 		})
+	default:
+		return 0, false, fmt.Errorf("unsupported vault auth type %s", authType)
 	}
 
 	if err != nil {
-		return err
+		return 0, false, err
 	}
-
-	// if the token has already been set
-	if c.Token() != "" {
-		return nil
+	if secret == nil || secret.Auth == nil {
+		return 0, false, fmt.Errorf("the %s auth method returned no token", authType)
 	}
 
 	log.Debug("client authenticated with auth backend: %s", authType)
-	// the default place for a token is in the auth section
-	// otherwise, the backend will set the token itself
 	c.SetToken(secret.Auth.ClientToken)
-	return nil
+	return time.Duration(secret.Auth.LeaseDuration) * time.Second, secret.Auth.Renewable, nil
+}
+
+// seconds converts a number of seconds of a JSON response to a duration.
+func seconds(v interface{}) time.Duration {
+	switch n := v.(type) {
+	case float64:
+		return time.Duration(n) * time.Second
+	case json.Number:
+		i, _ := n.Int64()
+		return time.Duration(i) * time.Second
+	}
+	return 0
 }
 
 func getConfig(address, cert, key, caCert string) (*vaultapi.Config, error) {
@@ -118,9 +229,31 @@ func getConfig(address, cert, key, caCert string) (*vaultapi.Config, error) {
 	return conf, nil
 }
 
+func init() {
+	backends.Register("vault", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		vaultConfig := map[string]string{
+			"app-id":   config.AppID,
+			"user-id":  config.UserID,
+			"username": config.Username,
+			"password": config.Password,
+			"token":    config.AuthToken,
+			"cert":     config.ClientCert,
+			"key":      config.ClientKey,
+			"caCert":   config.ClientCaKeys,
+		}
+		return New(config.BackendNodes[0], config.AuthType, vaultConfig, opts)
+	})
+}
+
 // New returns an *vault.Client with a connection to named machines.
 // It returns an error if a connection to the cluster cannot be made.
-func New(address, authType string, params map[string]string) (*Client, error) {
+// The token is renewed in the background, and obtained again by logging
+// in when it cannot be renewed any more.
+func New(address, authType string, params map[string]string, opts Options) (*Client, error) {
 	if authType == "" {
 		return nil, errors.New("you have to set the auth type when using the vault backend")
 	}
@@ -136,42 +269,46 @@ func New(address, authType string, params map[string]string) (*Client, error) {
 		return nil, err
 	}
 
-	if err := authenticate(c, authType, params); err != nil {
+	client := &Client{client: c, authType: authType, params: params, opts: opts}
+	ttl, renewable, err := authenticate(c, authType, params, opts)
+	if err != nil {
 		return nil, err
 	}
-	return &Client{c}, nil
+	go client.keepToken(ttl, renewable)
+	return client, nil
 }
 
-// GetValues queries etcd for keys prefixed by prefix.
+// GetValues queries vault for keys and the secrets below them. On KV
+// version 2 mounts, a key ending with ?version=N reads version N of a
+// secret.
 func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	c.authMu.RLock()
+	defer c.authMu.RUnlock()
+
 	vars := make(map[string]string)
 	for _, key := range keys {
-		log.Debug("getting %s from vault", key)
-		resp, err := c.client.Logical().Read(key)
-
-		if err != nil {
-			log.Debug("there was an error extracting %s", key)
+		if err := c.walk(key, vars); err != nil {
 			return nil, err
 		}
-		if resp == nil || resp.Data == nil {
-			continue
-		}
-
-		// if the key has only one string value
-		// treat it as a string and not a map of values
-		if val, ok := isKV(resp.Data); ok {
-			vars[key] = val
-		} else {
-			// save the json encoded response
-			// and flatten it to allow usage of gets & getvs
-			js, _ := json.Marshal(resp.Data)
-			vars[key] = string(js)
-			flatten(key, resp.Data, vars)
-		}
 	}
 	return vars, nil
 }
 
+// store sets the data of the secret at key in vars.
+func store(key string, data map[string]interface{}, vars map[string]string) {
+	// if the key has only one string value
+	// treat it as a string and not a map of values
+	if val, ok := isKV(data); ok {
+		vars[key] = val
+	} else {
+		// save the json encoded response
+		// and flatten it to allow usage of gets & getvs
+		js, _ := json.Marshal(data)
+		vars[key] = string(js)
+		flatten.Walk(key, data, vars)
+	}
+}
+
 // isKV checks if a given map has only one key of type string
 // if so, returns the value of that key
 func isKV(data map[string]interface{}) (string, bool) {
@@ -184,26 +321,3 @@ func isKV(data map[string]interface{}) (string, bool) {
 	}
 	return "", false
 }
-
-// recursively walks on all the values of a specific key and set them in the variables map
-func flatten(key string, value interface{}, vars map[string]string) {
-	switch value.(type) {
-	case string:
-		log.Debug("setting key %s to: %s", key, value)
-		vars[key] = value.(string)
-	case map[string]interface{}:
-		inner := value.(map[string]interface{})
-		for innerKey, innerValue := range inner {
-			innerKey = path.Join(key, "/", innerKey)
-			flatten(innerKey, innerValue, vars)
-		}
-	default: // we don't know how to handle non string or maps of strings
-		log.Warning("type of '%s' is not supported (%T)", key, value)
-	}
-}
-
-// WatchPrefix - not implemented at the moment
-func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	<-stopChan
-	return 0, nil
-}
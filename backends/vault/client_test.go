@@ -0,0 +1,37 @@
+package vault
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	var data map[string]interface{}
+	raw := `{"port": 5432, "tls": true, "hosts": ["db-1", "db-2"], "replica": null, "pool": {"size": 10}}`
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		t.Fatal(err)
+	}
+	vars := make(map[string]string)
+	store("/secret/db", data, vars)
+
+	js, _ := json.Marshal(data)
+	want := map[string]string{
+		"/secret/db":           string(js),
+		"/secret/db/port":      "5432",
+		"/secret/db/tls":       "true",
+		"/secret/db/hosts/0":   "db-1",
+		"/secret/db/hosts/1":   "db-2",
+		"/secret/db/replica":   "null",
+		"/secret/db/pool/size": "10",
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("store() = %v, want %v", vars, want)
+	}
+
+	vars = make(map[string]string)
+	store("/secret/password", map[string]interface{}{"value": "p@sSw0rd"}, vars)
+	if want := map[string]string{"/secret/password": "p@sSw0rd"}; !reflect.DeepEqual(vars, want) {
+		t.Errorf("store() = %v, want %v", vars, want)
+	}
+}
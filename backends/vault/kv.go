@@ -0,0 +1,202 @@
+package vault
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/kelseyhightower/confd/log"
+)
+
+// versionParam introduces the version of a KV version 2 secret in a key,
+// as in /secret/app?version=3.
+const versionParam = "?version="
+
+// mount is a secrets engine mount.
+type mount struct {
+	// path is the path of the mount, with a trailing slash.
+	path string
+	// kind is the type of the secrets engine, "kv" or "generic" for the
+	// key/value engine.
+	kind string
+	// version is the version of the key/value engine.
+	version int
+}
+
+// kv reports whether m is a key/value mount, whose prefixes can be listed.
+func (m *mount) kv() bool {
+	return m.kind == "kv" || m.kind == "generic"
+}
+
+// apiPath returns the path of the API of a KV version 2 mount, such as
+// "data" or "metadata", holding p.
+func (m *mount) apiPath(api, p string) string {
+	rel := strings.TrimSuffix(strings.TrimPrefix(p+"/", m.path), "/")
+	return m.path + api + "/" + rel
+}
+
+// mountOf returns the mount holding p, a path without leading slash. It
+// returns nil if the mount cannot be determined, as with Vault servers
+// predating KV version 2 or tokens not allowed to look it up.
+func (c *Client) mountOf(p string) *mount {
+	c.mu.Lock()
+	for _, m := range c.mounts {
+		if strings.HasPrefix(p+"/", m.path) {
+			c.mu.Unlock()
+			return m
+		}
+	}
+	c.mu.Unlock()
+
+	r := c.client.NewRequest("GET", "/v1/sys/internal/ui/mounts/"+p)
+	resp, err := c.client.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		log.Debug("cannot look up the mount of %s: %s", p, err)
+		return nil
+	}
+	var info struct {
+		Data struct {
+			Path    string
+			Type    string
+			Options map[string]string
+		}
+	}
+	if err := resp.DecodeJSON(&info); err != nil || info.Data.Path == "" {
+		log.Debug("cannot look up the mount of %s: unexpected response", p)
+		return nil
+	}
+
+	m := &mount{path: info.Data.Path, kind: info.Data.Type, version: 1}
+	if info.Data.Options["version"] == "2" {
+		m.version = 2
+	}
+	log.Debug("%s is on mount %s of type %s, version %d", p, m.path, m.kind, m.version)
+	c.mu.Lock()
+	c.mounts = append(c.mounts, m)
+	c.mu.Unlock()
+	return m
+}
+
+// read reads the secret at p, version version of it if version is not
+// empty. It returns nil if there is no such secret.
+func (c *Client) read(p string, m *mount, version string) (*vaultapi.Secret, error) {
+	if m == nil || !m.kv() || m.version < 2 {
+		if version != "" {
+			return nil, fmt.Errorf("cannot read version %s of %s, it is not on a KV version 2 mount", version, p)
+		}
+		return c.client.Logical().Read(p)
+	}
+
+	r := c.client.NewRequest("GET", "/v1/"+m.apiPath("data", p))
+	if version != "" {
+		r.Params.Set("version", version)
+	}
+	resp, err := c.client.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	secret, err := vaultapi.ParseSecret(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// The secret is wrapped along with its metadata. Deleted versions have
+	// no data.
+	data, _ := secret.Data["data"].(map[string]interface{})
+	if data == nil {
+		return nil, nil
+	}
+	secret.Data = data
+	return secret, nil
+}
+
+// list returns the names of the secrets and folders below p on mount m.
+// Folders end with a slash.
+func (c *Client) list(p string, m *mount) ([]string, error) {
+	if m.version >= 2 {
+		p = m.apiPath("metadata", p)
+	}
+	secret, err := c.client.Logical().List(strings.TrimSuffix(p, "/") + "/")
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	raw, _ := secret.Data["keys"].([]interface{})
+	names := make([]string, 0, len(raw))
+	for _, name := range raw {
+		if s, ok := name.(string); ok {
+			names = append(names, s)
+		}
+	}
+	return names, nil
+}
+
+// walk stores the secret at key in vars and, on key/value mounts, the
+// secrets below key. A key ending with versionParam is read at that
+// version and not walked.
+func (c *Client) walk(key string, vars map[string]string) error {
+	p, version := key, ""
+	if i := strings.LastIndex(key, versionParam); i >= 0 {
+		p, version = key[:i], key[i+len(versionParam):]
+	}
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+
+	m := c.mountOf(p)
+	if err := c.readInto(key, p, m, version, vars); err != nil {
+		return err
+	}
+	if version != "" || m == nil || !m.kv() {
+		return nil
+	}
+	if err := c.walkBelow(key, p, m, vars); err != nil {
+		// Tokens allowed to read a secret are not always allowed to list.
+		log.Warning("cannot list the secrets below %s: %s", key, err)
+	}
+	return nil
+}
+
+// readInto stores the secret at p in vars under key.
+func (c *Client) readInto(key, p string, m *mount, version string, vars map[string]string) error {
+	log.Debug("getting %s from vault", key)
+	secret, err := c.read(p, m, version)
+	if err != nil {
+		log.Debug("there was an error extracting %s", key)
+		return err
+	}
+	if secret != nil && secret.Data != nil {
+		store(key, secret.Data, vars)
+		c.track(key, secret)
+	}
+	return nil
+}
+
+// walkBelow stores the secrets below p, recursively, in vars under key.
+func (c *Client) walkBelow(key, p string, m *mount, vars map[string]string) error {
+	names, err := c.list(p, m)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		childKey, childPath := path.Join(key, name), path.Join(p, name)
+		if strings.HasSuffix(name, "/") {
+			err = c.walkBelow(childKey, childPath, m, vars)
+		} else {
+			err = c.readInto(childKey, childPath, m, "", vars)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeVault serves a KV version 2 mount at kv2/ and a version 1 mount at
+// kv1/, with the secrets of the tests.
+func fakeVault(t *testing.T) *httptest.Server {
+	reply := func(w http.ResponseWriter, data interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := r.URL.Query().Get("list") == "true"
+		p := strings.TrimLeft(strings.TrimPrefix(r.URL.Path, "/v1"), "/")
+		switch {
+		case p == "auth/token/lookup-self":
+			reply(w, map[string]interface{}{"id": "token", "ttl": 0})
+		case strings.HasPrefix(p, "sys/internal/ui/mounts/kv2"):
+			reply(w, map[string]interface{}{"path": "kv2/", "type": "kv", "options": map[string]string{"version": "2"}})
+		case strings.HasPrefix(p, "sys/internal/ui/mounts/kv1"):
+			reply(w, map[string]interface{}{"path": "kv1/", "type": "generic"})
+		case list && p == "kv2/metadata/app/":
+			reply(w, map[string]interface{}{"keys": []string{"db", "nested/"}})
+		case list && p == "kv2/metadata/app/nested/":
+			reply(w, map[string]interface{}{"keys": []string{"cache"}})
+		case p == "kv2/data/app/db":
+			data := map[string]interface{}{"password": "v2"}
+			if r.URL.Query().Get("version") == "1" {
+				data["password"] = "v1"
+			}
+			reply(w, map[string]interface{}{"data": data, "metadata": map[string]interface{}{"version": 2}})
+		case p == "kv2/data/app/nested/cache":
+			reply(w, map[string]interface{}{"data": map[string]interface{}{"value": "redis"}})
+		case list && p == "kv1/app/":
+			reply(w, map[string]interface{}{"keys": []string{"db"}})
+		case p == "kv1/app/db":
+			reply(w, map[string]interface{}{"user": "confd"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+}
+
+func TestGetValuesKV(t *testing.T) {
+	s := fakeVault(t)
+	defer s.Close()
+
+	c, err := New(s.URL, "token", map[string]string{"token": "token"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := c.GetValues([]string{"/kv2/app", "/kv2/app/db?version=1", "/kv1/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/kv2/app/db":                    `{"password":"v2"}`,
+		"/kv2/app/db/password":           "v2",
+		"/kv2/app/nested/cache":          "redis",
+		"/kv2/app/db?version=1":          `{"password":"v1"}`,
+		"/kv2/app/db?version=1/password": "v1",
+		"/kv1/app/db":                    `{"user":"confd"}`,
+		"/kv1/app/db/user":               "confd",
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+
+	if _, err := c.GetValues([]string{"/kv1/app/db?version=1"}); err == nil {
+		t.Error("GetValues() read a version of a KV version 1 secret")
+	}
+}
@@ -0,0 +1,203 @@
+package vault
+
+import (
+	"sort"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/kelseyhightower/confd/backends/flatten"
+	"github.com/kelseyhightower/confd/log"
+)
+
+// maxExpiredLeases is the number of expired leases remembered for the
+// watches that were not waiting when they expired.
+const maxExpiredLeases = 64
+
+// lease is the lease of a dynamic secret, such as database credentials.
+// Every read of such a secret returns a new lease, the templates rendered
+// with the previous one keep relying on it until they are rendered again.
+type lease struct {
+	id  string
+	key string
+	// read is the index at which the secret was read.
+	read uint64
+	// refs is the number of watches relying on the lease.
+	refs int
+	// expiring is closed once the secret should be read again, when two
+	// thirds of the lease elapsed and it is not extended.
+	expiring chan struct{}
+	expired  bool
+}
+
+// track starts following the lease of the secret read at key, if any.
+func (c *Client) track(key string, secret *vaultapi.Secret) {
+	if secret.LeaseID == "" || secret.LeaseDuration <= 0 {
+		return
+	}
+	c.mu.Lock()
+	if c.leases == nil {
+		c.leases = make(map[string]*lease)
+	}
+	if _, ok := c.leases[secret.LeaseID]; ok {
+		c.mu.Unlock()
+		return
+	}
+	c.leaseIndex++
+	l := &lease{
+		id:       secret.LeaseID,
+		key:      key,
+		read:     c.leaseIndex,
+		expiring: make(chan struct{}),
+	}
+	c.leases[l.id] = l
+	c.mu.Unlock()
+
+	log.Debug("following lease %s of %s for %ds", l.id, key, secret.LeaseDuration)
+	go c.keepLease(l, time.Duration(secret.LeaseDuration)*time.Second, secret.Renewable)
+}
+
+// reliedOn reports whether a template may still be rendered with the secret
+// of l: a watch relies on it, or it is the last lease read for its key.
+// c.mu must be held.
+func (c *Client) reliedOn(l *lease) bool {
+	if l.refs > 0 {
+		return true
+	}
+	for _, other := range c.leases {
+		if other.key == l.key && other.read > l.read && !other.expired {
+			return false
+		}
+	}
+	return true
+}
+
+// keepLease renews a renewable lease once two thirds of its duration have
+// elapsed, for as long as it is relied on. Once the lease is not extended,
+// it marks it as expiring with a third of its duration left.
+func (c *Client) keepLease(l *lease, ttl time.Duration, renewable bool) {
+	for {
+		wait := ttl * 2 / 3
+		<-time.After(wait)
+		c.mu.Lock()
+		relied := c.reliedOn(l)
+		c.mu.Unlock()
+		if !renewable || !relied {
+			break
+		}
+		renewed, err := c.renewLease(l.id, ttl)
+		if err != nil {
+			log.Warning("cannot renew lease %s of %s: %s", l.id, l.key, err)
+			break
+		}
+		if renewed <= ttl-wait {
+			log.Info("lease %s of %s reached its max TTL", l.id, l.key)
+			break
+		}
+		log.Debug("renewed lease %s of %s for %s", l.id, l.key, renewed)
+		ttl = renewed
+	}
+	c.mu.Lock()
+	c.leaseIndex++
+	l.expired = true
+	c.forgetExpired()
+	c.mu.Unlock()
+	close(l.expiring)
+}
+
+// forgetExpired drops the oldest expired leases past maxExpiredLeases. c.mu
+// must be held.
+func (c *Client) forgetExpired() {
+	var expired []*lease
+	for _, l := range c.leases {
+		if l.expired {
+			expired = append(expired, l)
+		}
+	}
+	if len(expired) <= maxExpiredLeases {
+		return
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].read < expired[j].read })
+	for _, l := range expired[:len(expired)-maxExpiredLeases] {
+		delete(c.leases, l.id)
+	}
+}
+
+// renewLease renews lease id for ttl and returns its new duration.
+func (c *Client) renewLease(id string, ttl time.Duration) (time.Duration, error) {
+	c.authMu.RLock()
+	defer c.authMu.RUnlock()
+	secret, err := c.client.Sys().Renew(id, int(ttl/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(secret.LeaseDuration) * time.Second, nil
+}
+
+// WatchPrefix returns when the lease of a secret below keys, read after
+// waitIndex, is about to expire, so that templates are rendered again with
+// a new secret. The leases stay renewed while a watch relies on them.
+// Secrets without lease are not watched.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	c.mu.Lock()
+	// Secrets read from now on are read at the index returned.
+	index := c.leaseIndex + 1
+	if waitIndex == 0 {
+		c.mu.Unlock()
+		return index, nil
+	}
+	var relied []*lease
+	for _, l := range c.leases {
+		if l.read < waitIndex {
+			continue
+		}
+		for _, k := range keys {
+			if flatten.IsChild(l.key, k) {
+				relied = append(relied, l)
+				break
+			}
+		}
+	}
+	for _, l := range relied {
+		if l.expired {
+			c.mu.Unlock()
+			return index, nil
+		}
+	}
+	for _, l := range relied {
+		l.refs++
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		for _, l := range relied {
+			l.refs--
+		}
+		c.mu.Unlock()
+	}()
+
+	// A single channel is woken up by any of the leases.
+	fired := make(chan struct{}, 1)
+	done := make(chan struct{})
+	defer close(done)
+	for _, l := range relied {
+		go func(ch chan struct{}) {
+			select {
+			case <-ch:
+				select {
+				case fired <- struct{}{}:
+				default:
+				}
+			case <-done:
+			}
+		}(l.expiring)
+	}
+
+	select {
+	case <-stopChan:
+		return waitIndex, nil
+	case <-fired:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.leaseIndex + 1, nil
+	}
+}
@@ -0,0 +1,178 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchPrefixLeases(t *testing.T) {
+	var mu sync.Mutex
+	renewals := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		p := strings.TrimLeft(strings.TrimPrefix(r.URL.Path, "/v1"), "/")
+		enc := json.NewEncoder(w)
+		switch p {
+		case "auth/token/lookup-self":
+			enc.Encode(map[string]interface{}{"data": map[string]interface{}{"ttl": 0}})
+		case "database/creds/app":
+			enc.Encode(map[string]interface{}{
+				"lease_id": "database/creds/app/1", "lease_duration": 1, "renewable": true,
+				"data": map[string]interface{}{"username": "u", "password": "p"},
+			})
+		case "aws/creds/app":
+			enc.Encode(map[string]interface{}{
+				"lease_id": "aws/creds/app/1", "lease_duration": 1, "renewable": false,
+				"data": map[string]interface{}{"access_key": "a"},
+			})
+		case "sys/renew/database/creds/app/1":
+			// The lease is extended once, then reaches its max TTL.
+			renewals++
+			duration := 1
+			if renewals > 1 {
+				duration = 0
+			}
+			enc.Encode(map[string]interface{}{"lease_id": "database/creds/app/1", "lease_duration": duration})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	c, err := New(s.URL, "token", map[string]string{"token": "token"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopChan := make(chan bool)
+	defer close(stopChan)
+	index, err := c.WatchPrefix("/", []string{"/database"}, 0, stopChan)
+	if err != nil || index == 0 {
+		t.Fatalf("WatchPrefix() = %d, %v, want an index right away", index, err)
+	}
+	if _, err := c.GetValues([]string{"/database/creds/app", "/aws/creds/app"}); err != nil {
+		t.Fatal(err)
+	}
+	read := time.Now()
+
+	watch := func(keys ...string) (time.Duration, uint64) {
+		next, err := c.WatchPrefix("/", keys, index, stopChan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Since(read), next
+	}
+
+	// The non-renewable lease expires first.
+	elapsed, next := watch("/aws")
+	if elapsed > time.Second || next <= index {
+		t.Errorf("WatchPrefix() = %d after %s, want a new index before the lease expires", next, elapsed)
+	}
+
+	// The renewable lease is extended once.
+	elapsed, next = watch("/database")
+	if elapsed < time.Second || elapsed > 2*time.Second || next <= index {
+		t.Errorf("WatchPrefix() = %d after %s, want a new index once the lease cannot be renewed", next, elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if renewals != 2 {
+		t.Errorf("renewals = %d, want 2", renewals)
+	}
+}
+
+func TestWatchPrefixSupersededLease(t *testing.T) {
+	var mu sync.Mutex
+	leases := 0
+	renewals := make(map[string]int)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		p := strings.TrimLeft(strings.TrimPrefix(r.URL.Path, "/v1"), "/")
+		enc := json.NewEncoder(w)
+		switch {
+		case p == "auth/token/lookup-self":
+			enc.Encode(map[string]interface{}{"data": map[string]interface{}{"ttl": 0}})
+		case p == "database/creds/app":
+			// Every read returns new credentials with their own lease.
+			leases++
+			enc.Encode(map[string]interface{}{
+				"lease_id": fmt.Sprintf("database/creds/app/%d", leases), "lease_duration": 1, "renewable": true,
+				"data": map[string]interface{}{"username": fmt.Sprintf("u%d", leases)},
+			})
+		case strings.HasPrefix(p, "sys/renew/"):
+			id := strings.TrimPrefix(p, "sys/renew/")
+			renewals[id]++
+			enc.Encode(map[string]interface{}{"lease_id": id, "lease_duration": 1})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	renewed := func(id string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return renewals[id]
+	}
+
+	c, err := New(s.URL, "token", map[string]string{"token": "token"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"/database"}
+	index, err := c.WatchPrefix("/", keys, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Resource b renders with the first lease and waits, then resource a
+	// renders with a second lease of the same secret.
+	if _, err := c.GetValues([]string{"/database/creds/app"}); err != nil {
+		t.Fatal(err)
+	}
+	stopB := make(chan bool)
+	doneB := make(chan uint64, 1)
+	go func() {
+		next, _ := c.WatchPrefix("/", keys, index, stopB)
+		doneB <- next
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.GetValues([]string{"/database/creds/app"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first lease stays renewed while resource b relies on it.
+	time.Sleep(1500 * time.Millisecond)
+	select {
+	case next := <-doneB:
+		t.Fatalf("WatchPrefix() of resource b = %d, want it to wait while its lease is renewed", next)
+	default:
+	}
+	if n := renewed("database/creds/app/1"); n == 0 {
+		t.Errorf("renewals of the first lease = %d, want it renewed while relied on", n)
+	}
+
+	// Once resource b stops waiting, the first lease is not renewed anymore
+	// and a watch relying on it returns once it expired.
+	close(stopB)
+	<-doneB
+	before := renewed("database/creds/app/1")
+	time.Sleep(time.Second)
+	if n := renewed("database/creds/app/1"); n != before {
+		t.Errorf("renewals of the first lease = %d, want %d", n, before)
+	}
+	start := time.Now()
+	next, err := c.WatchPrefix("/", keys, index, nil)
+	if err != nil || next <= index || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("WatchPrefix() = %d, %v after %s, want a new index right away", next, err, time.Since(start))
+	}
+	if n := renewed("database/creds/app/2"); n == 0 {
+		t.Errorf("renewals of the second lease = %d, want it renewed as the last lease read", n)
+	}
+}
@@ -0,0 +1,68 @@
+package vault
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/confd/log"
+)
+
+// loginRetryInterval is the delay between two failed logins.
+var loginRetryInterval = 10 * time.Second
+
+// keepToken keeps the token of the client valid. The token is renewed once
+// two thirds of its TTL have elapsed. When renewal fails, or the max TTL of
+// the token keeps it from being extended, the client logs in again. Tokens
+// without TTL are left alone.
+func (c *Client) keepToken(ttl time.Duration, renewable bool) {
+	for ttl > 0 {
+		wait := ttl * 2 / 3
+		time.Sleep(wait)
+		if renewable {
+			renewed, err := c.renewToken()
+			switch {
+			case err != nil:
+				log.Warning("cannot renew the vault token, logging in again: %s", err)
+			case renewed <= ttl-wait:
+				log.Info("the vault token reached its max TTL, logging in again")
+			default:
+				log.Debug("renewed the vault token for %s", renewed)
+				ttl = renewed
+				continue
+			}
+		}
+		if c.authType == "token" {
+			log.Error("the vault token cannot be renewed and expires in %s", ttl-wait)
+			return
+		}
+		ttl, renewable = c.login()
+	}
+}
+
+// renewToken renews the token and returns its new TTL.
+func (c *Client) renewToken() (time.Duration, error) {
+	c.authMu.RLock()
+	defer c.authMu.RUnlock()
+	secret, err := c.client.Auth().Token().RenewSelf(0)
+	if err != nil {
+		return 0, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return 0, nil
+	}
+	return time.Duration(secret.Auth.LeaseDuration) * time.Second, nil
+}
+
+// login logs in until it succeeds and returns the TTL of the new token and
+// whether it can be renewed.
+func (c *Client) login() (time.Duration, bool) {
+	for {
+		c.authMu.Lock()
+		ttl, renewable, err := authenticate(c.client, c.authType, c.params, c.opts)
+		c.authMu.Unlock()
+		if err == nil {
+			return ttl, renewable
+		}
+		log.Error("cannot log in to vault with %s, retrying in %s: %s", c.authType, loginRetryInterval, err)
+		time.Sleep(loginRetryInterval)
+	}
+}
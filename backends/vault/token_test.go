@@ -0,0 +1,139 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAuth answers logins with tokens t1, t2... of the given lease, and
+// renews the tokens once before refusing to extend them.
+type fakeAuth struct {
+	lease int
+
+	mu      sync.Mutex
+	logins  []string
+	bodies  []map[string]interface{}
+	renewed map[string]bool
+}
+
+func (a *fakeAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := strings.TrimLeft(strings.TrimPrefix(r.URL.Path, "/v1"), "/")
+	token := r.Header.Get("X-Vault-Token")
+	auth := func(token string, lease int) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token, "lease_duration": lease, "renewable": true},
+		})
+	}
+	switch {
+	case strings.HasSuffix(p, "/login"):
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		a.logins = append(a.logins, p)
+		a.bodies = append(a.bodies, body)
+		auth(fmt.Sprintf("t%d", len(a.logins)), a.lease)
+	case p == "auth/token/renew-self":
+		if a.renewed[token] {
+			auth(token, 0)
+		} else {
+			a.renewed[token] = true
+			auth(token, a.lease)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (a *fakeAuth) loginCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.logins)
+}
+
+func TestApproleRenewal(t *testing.T) {
+	a := &fakeAuth{lease: 1, renewed: make(map[string]bool)}
+	s := httptest.NewServer(a)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "confd-vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretIDFile := filepath.Join(dir, "secret-id")
+	if err := ioutil.WriteFile(secretIDFile, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(s.URL, "approle", map[string]string{}, Options{RoleID: "confd", SecretIDFile: secretIDFile, AuthMount: "ci-approle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token is renewed once, then logged in again at its max TTL.
+	deadline := time.Now().Add(5 * time.Second)
+	for a.loginCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the client did not log in again when the token reached its max TTL")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if want := []string{"auth/ci-approle/login", "auth/ci-approle/login"}; !reflect.DeepEqual(a.logins[:2], want) {
+		t.Errorf("logins = %v, want %v", a.logins, want)
+	}
+	if want := map[string]interface{}{"role_id": "confd", "secret_id": "s3cr3t"}; !reflect.DeepEqual(a.bodies[0], want) {
+		t.Errorf("login = %v, want %v", a.bodies[0], want)
+	}
+	if !a.renewed["t1"] {
+		t.Error("the first token was not renewed")
+	}
+	c.authMu.RLock()
+	defer c.authMu.RUnlock()
+	if c.client.Token() == "t1" {
+		t.Error("the client still uses the expired token")
+	}
+}
+
+func TestKubernetesLogin(t *testing.T) {
+	a := &fakeAuth{renewed: make(map[string]bool)}
+	s := httptest.NewServer(a)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "confd-vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jwtFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(jwtFile, []byte("jwt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(s.URL, "kubernetes", map[string]string{}, Options{ServiceAccountTokenFile: jwtFile}); err == nil {
+		t.Error("New() logged in without a kubernetes role")
+	}
+	if _, err := New(s.URL, "kubernetes", map[string]string{}, Options{Role: "web", ServiceAccountTokenFile: jwtFile}); err != nil {
+		t.Fatal(err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.logins) != 1 || a.logins[0] != "auth/kubernetes/login" {
+		t.Fatalf("logins = %v, want auth/kubernetes/login", a.logins)
+	}
+	if want := map[string]interface{}{"role": "web", "jwt": "jwt"}; !reflect.DeepEqual(a.bodies[0], want) {
+		t.Errorf("login = %v, want %v", a.bodies[0], want)
+	}
+}
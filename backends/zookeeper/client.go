@@ -1,35 +1,136 @@
 package zookeeper
 
 import (
-	"path/filepath"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/kelseyhightower/confd/backends"
 	"github.com/kelseyhightower/confd/log"
 	zk "github.com/samuel/go-zookeeper/zk"
 )
 
+// defaultSessionTimeout is the session timeout unless options set another.
+const defaultSessionTimeout = 10 * time.Second
+
+// Options are the ZooKeeper specific settings, set as backend options.
+type Options struct {
+	// SessionTimeout is the timeout of the session, within which the
+	// client may reconnect and keep its watches. It also bounds the wait
+	// for the first session.
+	SessionTimeout time.Duration `mapstructure:"session_timeout"`
+	// Chroot is the node the keys are relative to.
+	Chroot string `mapstructure:"chroot"`
+}
+
 // Client provides a wrapper around the zookeeper client
 type Client struct {
 	client *zk.Conn
+	chroot string
+	// auth is the digest credentials, added to every new session.
+	auth []byte
+
+	watcherOnce sync.Once
+	watcher     *watcher
+}
+
+func init() {
+	backends.Register("zookeeper", func(config backends.Config) (backends.StoreClient, error) {
+		var opts Options
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return NewZookeeperClient(config.BackendNodes, config.Username, config.Password, opts)
+	})
 }
 
-func NewZookeeperClient(machines []string) (*Client, error) {
-	c, _, err := zk.Connect(machines, time.Second) //*10)
+// NewZookeeperClient connects to machines and waits for a session. If
+// username is set, the client authenticates with the digest scheme.
+func NewZookeeperClient(machines []string, username, password string, opts Options) (*Client, error) {
+	if opts.SessionTimeout <= 0 {
+		opts.SessionTimeout = defaultSessionTimeout
+	}
+	if password != "" && username == "" {
+		return nil, errors.New("a username is required for digest authentication")
+	}
+	c := &Client{chroot: strings.TrimSuffix(opts.Chroot, "/")}
+	if c.chroot != "" && !strings.HasPrefix(c.chroot, "/") {
+		return nil, fmt.Errorf("chroot %s is not an absolute path", opts.Chroot)
+	}
+	if username != "" {
+		c.auth = []byte(username + ":" + password)
+	}
+
+	conn, events, err := zk.Connect(machines, opts.SessionTimeout)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	c.client = conn
+	connected := make(chan error, 1)
+	go c.session(events, connected)
+	select {
+	case err = <-connected:
+	case <-time.After(opts.SessionTimeout):
+		err = fmt.Errorf("no session with %s within %s", strings.Join(machines, ", "), opts.SessionTimeout)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// session follows the state of the connection until it is closed. The
+// credentials are added again on every new connection, the server forgets
+// them when the client reconnects. The result of the first authentication
+// is sent to connected.
+func (c *Client) session(events <-chan zk.Event, connected chan<- error) {
+	for e := range events {
+		if e.Type != zk.EventSession {
+			continue
+		}
+		switch e.State {
+		case zk.StateHasSession:
+			var err error
+			if c.auth != nil {
+				err = c.client.AddAuth("digest", c.auth)
+				if err != nil {
+					err = fmt.Errorf("cannot authenticate to %s: %s", e.Server, err)
+					log.Error("%s", err)
+				}
+			}
+			select {
+			case connected <- err:
+			default:
+			}
+		case zk.StateExpired:
+			// The watches are lost, they are set again by the next
+			// WatchPrefix.
+			log.Warning("zookeeper session expired, starting a new one")
+		case zk.StateAuthFailed:
+			log.Error("zookeeper authentication failed")
+		}
 	}
-	return &Client{c}, nil
+}
+
+// path returns the node of key, below the chroot.
+func (c *Client) path(key string) string {
+	if p := c.chroot + strings.TrimSuffix(key, "/"); p != "" {
+		return p
+	}
+	return "/"
 }
 
 func nodeWalk(prefix string, c *Client, vars map[string]string) error {
-	l, stat, err := c.client.Children(prefix)
+	l, stat, err := c.client.Children(c.path(prefix))
 	if err != nil {
 		return err
 	}
 
 	if stat.NumChildren == 0 {
-		b, _, err := c.client.Get(prefix)
+		b, _, err := c.client.Get(c.path(prefix))
 		if err != nil {
 			return err
 		}
@@ -38,18 +139,18 @@ func nodeWalk(prefix string, c *Client, vars map[string]string) error {
 	} else {
 		for _, key := range l {
 			s := prefix + "/" + key
-			_, stat, err := c.client.Exists(s)
+			_, stat, err := c.client.Exists(c.path(s))
 			if err != nil {
 				return err
 			}
 			if stat.NumChildren == 0 {
-				b, _, err := c.client.Get(s)
+				b, _, err := c.client.Get(c.path(s))
 				if err != nil {
 					return err
 				}
 				vars[s] = string(b)
-			} else {
-				nodeWalk(s, c, vars)
+			} else if err := nodeWalk(s, c, vars); err != nil {
+				return err
 			}
 		}
 	}
@@ -60,7 +161,7 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	vars := make(map[string]string)
 	for _, v := range keys {
 		v = strings.Replace(v, "/*", "", -1)
-		_, _, err := c.client.Exists(v)
+		_, _, err := c.client.Exists(c.path(v))
 		if err != nil {
 			return vars, err
 		}
@@ -74,90 +175,3 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	}
 	return vars, nil
 }
-
-type watchResponse struct {
-	waitIndex uint64
-	err       error
-}
-
-func (c *Client) watch(key string, respChan chan watchResponse, cancelRoutine chan bool) {
-	_, _, keyEventCh, err := c.client.GetW(key)
-	if err != nil {
-		respChan <- watchResponse{0, err}
-	}
-	_, _, childEventCh, err := c.client.ChildrenW(key)
-	if err != nil {
-		respChan <- watchResponse{0, err}
-	}
-
-	for {
-		select {
-		case e := <-keyEventCh:
-			if e.Type == zk.EventNodeDataChanged {
-				respChan <- watchResponse{1, e.Err}
-			}
-		case e := <-childEventCh:
-			if e.Type == zk.EventNodeChildrenChanged {
-				respChan <- watchResponse{1, e.Err}
-			}
-		case <-cancelRoutine:
-			log.Debug("Stop watching: " + key)
-			// There is no way to stop GetW/ChildrenW so just quit
-			return
-		}
-	}
-}
-
-func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	// return something > 0 to trigger a key retrieval from the store
-	if waitIndex == 0 {
-		return 1, nil
-	}
-
-	// List the childrens first
-	entries, err := c.GetValues([]string{prefix})
-	if err != nil {
-		return 0, err
-	}
-
-	respChan := make(chan watchResponse)
-	cancelRoutine := make(chan bool)
-	defer close(cancelRoutine)
-
-	//watch all subfolders for changes
-	watchMap := make(map[string]string)
-	for k, _ := range entries {
-		for _, v := range keys {
-			if strings.HasPrefix(k, v) {
-				for dir := filepath.Dir(k); dir != "/"; dir = filepath.Dir(dir) {
-					if _, ok := watchMap[dir]; !ok {
-						watchMap[dir] = ""
-						log.Debug("Watching: " + dir)
-						go c.watch(dir, respChan, cancelRoutine)
-					}
-				}
-				break
-			}
-		}
-	}
-
-	//watch all keys in prefix for changes
-	for k, _ := range entries {
-		for _, v := range keys {
-			if strings.HasPrefix(k, v) {
-				log.Debug("Watching: " + k)
-				go c.watch(k, respChan, cancelRoutine)
-				break
-			}
-		}
-	}
-
-	for {
-		select {
-		case <-stopChan:
-			return waitIndex, nil
-		case r := <-respChan:
-			return r.waitIndex, r.err
-		}
-	}
-}
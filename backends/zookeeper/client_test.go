@@ -0,0 +1,57 @@
+package zookeeper
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetValuesChroot(t *testing.T) {
+	c, s := newTestClient(t, map[string]string{
+		"/confd":       "",
+		"/confd/app":   "",
+		"/confd/app/a": "1",
+		"/confd/app/b": "2",
+		"/app":         "",
+		"/app/a":       "outside",
+	}, Options{Chroot: "/confd/"})
+	defer s.close()
+
+	vars, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"/app/a": "1", "/app/b": "2"}; !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if want := []string{"digest confd:s3cr3t"}; !reflect.DeepEqual(s.auths, want) {
+		t.Errorf("auths = %v, want %v", s.auths, want)
+	}
+}
+
+func TestNewZookeeperClientErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	start := time.Now()
+	if _, err := NewZookeeperClient([]string{addr}, "", "", Options{SessionTimeout: 200 * time.Millisecond}); err == nil {
+		t.Error("NewZookeeperClient() connected to a closed port")
+	}
+	// Closing the connection may take another second.
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("NewZookeeperClient() gave up after %s, want the session timeout", elapsed)
+	}
+	if _, err := NewZookeeperClient([]string{addr}, "", "s3cr3t", Options{}); err == nil {
+		t.Error("NewZookeeperClient() accepted a password without username")
+	}
+	if _, err := NewZookeeperClient([]string{addr}, "", "", Options{Chroot: "confd"}); err == nil {
+		t.Error("NewZookeeperClient() accepted a relative chroot")
+	}
+}
@@ -0,0 +1,289 @@
+package zookeeper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Operations and event types of the ZooKeeper protocol.
+const (
+	opExists       = 3
+	opGetData      = 4
+	opPing         = 11
+	opGetChildren2 = 12
+	opSetAuth      = 100
+	opSetWatches   = 101
+
+	eventNodeCreated         = 1
+	eventNodeDataChanged     = 3
+	eventNodeChildrenChanged = 4
+
+	errNoNode = -101
+)
+
+// fakeServer is a ZooKeeper server holding nodes in memory. It records the
+// credentials added to its sessions and can expire them.
+type fakeServer struct {
+	l net.Listener
+
+	mu      sync.Mutex
+	nodes   map[string]string
+	session int64
+	conns   map[*fakeConn]bool
+	watches map[string][]*fakeConn
+	auths   []string
+}
+
+// fakeConn is a client connection, written by its requests and by events.
+type fakeConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func (c *fakeConn) send(p *packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := p.Bytes()
+	binary.Write(c, binary.BigEndian, int32(len(b)))
+	c.Write(b)
+}
+
+// packet encodes the fields of the protocol.
+type packet struct {
+	bytes.Buffer
+}
+
+func (p *packet) int32(v int32) *packet {
+	binary.Write(p, binary.BigEndian, v)
+	return p
+}
+
+func (p *packet) int64(v int64) *packet {
+	binary.Write(p, binary.BigEndian, v)
+	return p
+}
+
+func (p *packet) string(s string) *packet {
+	p.int32(int32(len(s)))
+	p.WriteString(s)
+	return p
+}
+
+// stat encodes the stat of a node, of which the client only reads the
+// number of children.
+func (p *packet) stat(children int) *packet {
+	p.Write(make([]byte, 56))
+	return p.int32(int32(children)).int64(0)
+}
+
+// header starts the reply to request xid.
+func header(xid int32, err int32) *packet {
+	return new(packet).int32(xid).int64(1).int32(err)
+}
+
+type reader struct {
+	*bytes.Reader
+}
+
+func (r reader) int32() int32 {
+	var v int32
+	binary.Read(r, binary.BigEndian, &v)
+	return v
+}
+
+func (r reader) int64() int64 {
+	var v int64
+	binary.Read(r, binary.BigEndian, &v)
+	return v
+}
+
+func (r reader) string() string {
+	b := make([]byte, r.int32())
+	io.ReadFull(r, b)
+	return string(b)
+}
+
+func (r reader) bool() bool {
+	b, _ := r.ReadByte()
+	return b != 0
+}
+
+func readPacket(c net.Conn) (reader, error) {
+	var n int32
+	if err := binary.Read(c, binary.BigEndian, &n); err != nil {
+		return reader{}, err
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(c, b)
+	return reader{bytes.NewReader(b)}, err
+}
+
+func newFakeServer(t *testing.T, nodes map[string]string) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		l:       l,
+		nodes:   map[string]string{"/": ""},
+		conns:   make(map[*fakeConn]bool),
+		watches: make(map[string][]*fakeConn),
+	}
+	for p, data := range nodes {
+		s.nodes[p] = data
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(&fakeConn{Conn: conn})
+		}
+	}()
+	return s
+}
+
+// newTestClient starts a fake server holding nodes and returns a client of
+// it, authenticated as confd.
+func newTestClient(t *testing.T, nodes map[string]string, opts Options) (*Client, *fakeServer) {
+	s := newFakeServer(t, nodes)
+	c, err := NewZookeeperClient([]string{s.addr()}, "confd", "s3cr3t", opts)
+	if err != nil {
+		s.close()
+		t.Fatal(err)
+	}
+	return c, s
+}
+
+func (s *fakeServer) addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *fakeServer) close() {
+	s.l.Close()
+	s.expire()
+}
+
+func (s *fakeServer) serve(c *fakeConn) {
+	defer c.Close()
+	r, err := readPacket(c)
+	if err != nil {
+		return
+	}
+	r.int32()
+	r.int64()
+	timeout := r.int32()
+	session := r.int64()
+
+	s.mu.Lock()
+	if session != 0 && session != s.session {
+		s.mu.Unlock()
+		c.send(new(packet).int32(0).int32(timeout).int64(0).string(""))
+		return
+	}
+	if session == 0 {
+		s.session++
+	}
+	session = s.session
+	s.conns[c] = true
+	s.mu.Unlock()
+	c.send(new(packet).int32(0).int32(timeout).int64(session).string("passwd"))
+
+	for {
+		r, err := readPacket(c)
+		if err != nil {
+			return
+		}
+		xid, op := r.int32(), r.int32()
+		s.mu.Lock()
+		switch op {
+		case opPing, opSetWatches:
+			c.send(header(xid, 0))
+		case opSetAuth:
+			r.int32()
+			scheme := r.string()
+			s.auths = append(s.auths, scheme+" "+r.string())
+			c.send(header(xid, 0))
+		case opExists, opGetData, opGetChildren2:
+			p, watch := r.string(), r.bool()
+			data, ok := s.nodes[p]
+			children := s.children(p)
+			switch {
+			case op == opGetChildren2 && watch && ok:
+				s.watches["child:"+p] = append(s.watches["child:"+p], c)
+			case watch:
+				s.watches["data:"+p] = append(s.watches["data:"+p], c)
+			}
+			switch {
+			case !ok:
+				c.send(header(xid, errNoNode))
+			case op == opExists:
+				c.send(header(xid, 0).stat(len(children)))
+			case op == opGetData:
+				c.send(header(xid, 0).string(data).stat(len(children)))
+			default:
+				reply := header(xid, 0).int32(int32(len(children)))
+				for _, child := range children {
+					reply.string(child)
+				}
+				c.send(reply.stat(len(children)))
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// children returns the names of the children of node p.
+func (s *fakeServer) children(p string) []string {
+	var names []string
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for node := range s.nodes {
+		if node != p && strings.HasPrefix(node, prefix) && !strings.Contains(node[len(prefix):], "/") {
+			names = append(names, node[len(prefix):])
+		}
+	}
+	return names
+}
+
+// set creates or changes node p, firing the watches.
+func (s *fakeServer) set(p, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.nodes[p]
+	s.nodes[p] = data
+	if ok {
+		s.fire("data:"+p, eventNodeDataChanged, p)
+		return
+	}
+	s.fire("data:"+p, eventNodeCreated, p)
+	parent := p[:strings.LastIndex(p, "/")]
+	if parent == "" {
+		parent = "/"
+	}
+	s.fire("child:"+parent, eventNodeChildrenChanged, parent)
+}
+
+func (s *fakeServer) fire(watch string, event int32, p string) {
+	for _, c := range s.watches[watch] {
+		c.send(header(-1, 0).int32(event).int32(3).string(p))
+	}
+	delete(s.watches, watch)
+}
+
+// expire ends the session and its connections, dropping their watches.
+func (s *fakeServer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session++
+	s.watches = make(map[string][]*fakeConn)
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+}
@@ -0,0 +1,137 @@
+package zookeeper
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/kelseyhightower/confd/backends/changes"
+	"github.com/kelseyhightower/confd/log"
+	zk "github.com/samuel/go-zookeeper/zk"
+)
+
+// watchKind is one of the two watches set on a node.
+type watchKind int
+
+const (
+	// dataWatch is set with ExistsW, it fires when the node is created,
+	// changed or deleted.
+	dataWatch watchKind = iota
+	// childWatch is set with ChildrenW, it fires when a child is created
+	// or deleted.
+	childWatch
+)
+
+type watch struct {
+	node string
+	kind watchKind
+}
+
+// watcher turns the ZooKeeper watches into an increasing index. Every
+// node is watched at most once of each kind, whatever the number of
+// WatchPrefix, so that a goroutine waits for each watch and exits once it
+// fired.
+type watcher struct {
+	*changes.Log
+	client *Client
+
+	mu    sync.Mutex
+	armed map[watch]bool
+}
+
+func newWatcher(c *Client) *watcher {
+	return &watcher{
+		Log:    changes.NewLog(),
+		client: c,
+		armed:  make(map[watch]bool),
+	}
+}
+
+// set marks w as armed, and reports whether it was not.
+func (w *watcher) set(wt watch) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.armed[wt] {
+		return false
+	}
+	w.armed[wt] = true
+	return true
+}
+
+func (w *watcher) unset(wt watch) {
+	w.mu.Lock()
+	delete(w.armed, wt)
+	w.mu.Unlock()
+}
+
+// arm watches node and the nodes below it, unless they are already
+// watched. A missing node is watched for its creation.
+func (w *watcher) arm(node string) error {
+	conn := w.client.client
+	p := w.client.path(node)
+	if wt := (watch{node, dataWatch}); w.set(wt) {
+		_, _, ch, err := conn.ExistsW(p)
+		if err != nil {
+			w.unset(wt)
+			return err
+		}
+		go w.wait(wt, ch)
+	}
+
+	var children []string
+	var err error
+	if wt := (watch{node, childWatch}); w.set(wt) {
+		var ch <-chan zk.Event
+		children, _, ch, err = conn.ChildrenW(p)
+		if err != nil {
+			w.unset(wt)
+		} else {
+			go w.wait(wt, ch)
+		}
+	} else {
+		children, _, err = conn.Children(p)
+	}
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range children {
+		if err := w.arm(strings.TrimSuffix(node, "/") + "/" + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait notifies the change of the node of wt once its watch fires. The node
+// is watched again before, so that no later change is missed, unless the
+// watch was dropped with the session.
+func (w *watcher) wait(wt watch, ch <-chan zk.Event) {
+	e := <-ch
+	w.unset(wt)
+	if e.Type != zk.EventNotWatching {
+		if err := w.arm(wt.node); err != nil {
+			log.Debug("cannot watch %s again: %s", wt.node, err)
+		}
+	}
+	w.Notify(wt.node)
+}
+
+// WatchPrefix watches the nodes below keys and returns once one of them
+// changed. After a session expiry, the nodes are watched again by the next
+// call.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	c.watcherOnce.Do(func() { c.watcher = newWatcher(c) })
+	w := c.watcher
+	for _, key := range keys {
+		if err := w.arm(strings.Replace(key, "/*", "", -1)); err != nil {
+			return waitIndex, err
+		}
+	}
+	// return something > 0 to trigger a key retrieval from the store
+	if waitIndex == 0 {
+		return w.Current(), nil
+	}
+	return w.Wait(keys, waitIndex, stopChan), nil
+}
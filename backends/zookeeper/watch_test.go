@@ -0,0 +1,66 @@
+package zookeeper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchPrefix(t *testing.T) {
+	c, s := newTestClient(t, map[string]string{"/app": "", "/app/a": "1"}, Options{SessionTimeout: 3 * time.Second})
+	defer s.close()
+	stopChan := make(chan bool)
+	defer close(stopChan)
+
+	index, err := c.WatchPrefix("/", []string{"/app"}, 0, stopChan)
+	if err != nil || index == 0 {
+		t.Fatalf("WatchPrefix() = %d, %v, want an index right away", index, err)
+	}
+	watch := func(change func()) {
+		t.Helper()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			change()
+		}()
+		next, err := c.WatchPrefix("/", []string{"/app"}, index, stopChan)
+		if err != nil || next <= index {
+			t.Fatalf("WatchPrefix() = %d, %v, want an index after %d", next, err, index)
+		}
+		index = next
+	}
+
+	// A change of value and a new key are both seen.
+	watch(func() { s.set("/app/a", "2") })
+	watch(func() { s.set("/app/b", "1") })
+
+	// Every node is watched once, however many watches ran. A change of
+	// value fires both watches of the node, which are set again in turn.
+	armed := func() int {
+		c.watcher.mu.Lock()
+		defer c.watcher.mu.Unlock()
+		return len(c.watcher.armed)
+	}
+	for deadline := time.Now().Add(time.Second); armed() != 6 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := armed(); n != 6 {
+		t.Errorf("armed watches = %d, want 6 for 3 nodes", n)
+	}
+
+	// Once the session expired, the credentials are added to the new
+	// session and the watches are set again.
+	watch(s.expire)
+	auths := func() []string {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.auths
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(auths()) != 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if a := auths(); len(a) != 2 {
+		t.Fatalf("auths = %v, want the credentials added to both sessions", a)
+	}
+	// Skip the other watches dropped with the session.
+	index = c.watcher.Current()
+	watch(func() { s.set("/app/a", "3") })
+}
@@ -23,11 +23,13 @@ var (
 	authToken         string
 	authType          string
 	backend           string
+	backendOptions    Nodes
 	basicAuth         bool
 	clientCaKeys      string
 	clientCert        string
 	clientKey         string
 	confdir           string
+	files             Nodes
 	config            Config // holds the global confd config.
 	interval          int
 	keepStageFile     bool
@@ -62,7 +64,9 @@ type Config struct {
 	ClientCert   string   `toml:"client_cert"`
 	ClientKey    string   `toml:"client_key"`
 	ConfDir      string   `toml:"confdir"`
+	File         []string `toml:"file"`
 	Interval     int      `toml:"interval"`
+	Layers       []Config `toml:"layers"`
 	Noop         bool     `toml:"noop"`
 	Password     string   `toml:"password"`
 	Prefix       string   `toml:"prefix"`
@@ -76,17 +80,22 @@ type Config struct {
 	Watch        bool     `toml:"watch"`
 	AppID        string   `toml:"app_id"`
 	UserID       string   `toml:"user_id"`
+
+	// BackendOptions holds the settings specific to the backend.
+	BackendOptions map[string]interface{} `toml:"backend_options"`
 }
 
 func init() {
 	flag.StringVar(&authToken, "auth-token", "", "Auth bearer token to use")
 	flag.StringVar(&backend, "backend", "etcd", "backend to use")
-	flag.BoolVar(&basicAuth, "basic-auth", false, "Use Basic Auth to authenticate (only used with -backend=etcd)")
+	flag.Var(&backendOptions, "backend-option", "backend specific option, as key=value, may be repeated")
+	flag.BoolVar(&basicAuth, "basic-auth", false, "Use Basic Auth to authenticate (only used with -backend=etcd and -backend=etcdv3)")
 	flag.StringVar(&clientCaKeys, "client-ca-keys", "", "client ca keys")
 	flag.StringVar(&clientCert, "client-cert", "", "the client cert")
 	flag.StringVar(&clientKey, "client-key", "", "the client key")
 	flag.StringVar(&confdir, "confdir", "/etc/confd", "confd conf directory")
 	flag.StringVar(&configFile, "config-file", "", "the confd config file")
+	flag.Var(&files, "file", "the YAML/JSON/TOML file or directory to watch for changes (only used with -backend=file)")
 	flag.IntVar(&interval, "interval", 600, "backend polling interval")
 	flag.BoolVar(&keepStageFile, "keep-stage-file", false, "keep staged files")
 	flag.StringVar(&logLevel, "log-level", "", "level which confd should log messages")
@@ -102,9 +111,9 @@ func init() {
 	flag.StringVar(&authType, "auth-type", "", "Vault auth backend type to use (only used with -backend=vault)")
 	flag.StringVar(&appID, "app-id", "", "Vault app-id to use with the app-id backend (only used with -backend=vault and auth-type=app-id)")
 	flag.StringVar(&userID, "user-id", "", "Vault user-id to use with the app-id backend (only used with -backend=value and auth-type=app-id)")
-	flag.StringVar(&table, "table", "", "the name of the DynamoDB table (only used with -backend=dynamodb)")
-	flag.StringVar(&username, "username", "", "the username to authenticate as (only used with vault and etcd backends)")
-	flag.StringVar(&password, "password", "", "the password to authenticate with (only used with vault and etcd backends)")
+	flag.StringVar(&table, "table", "", "the name of the DynamoDB or SQL table (only used with -backend=dynamodb and -backend=sql)")
+	flag.StringVar(&username, "username", "", "the username to authenticate as (only used with vault, etcd, etcdv3 and zookeeper backends)")
+	flag.StringVar(&password, "password", "", "the password to authenticate with (only used with vault, etcd, etcdv3, redis and zookeeper backends)")
 	flag.BoolVar(&watch, "watch", false, "enable watch support")
 }
 
@@ -147,88 +156,168 @@ func initConfig() error {
 
 	// Update config from commandline flags.
 	processFlags()
+	if err := setBackendOptions(&config, backendOptions); err != nil {
+		return err
+	}
 
 	if config.LogLevel != "" {
 		log.SetLevel(config.LogLevel)
 	}
 
-	if config.SRVDomain != "" && config.SRVRecord == "" {
-		config.SRVRecord = fmt.Sprintf("_%s._tcp.%s.", config.Backend, config.SRVDomain)
+	var err error
+	backendsConfig, err = newBackendsConfig(&config)
+	if err != nil {
+		return err
+	}
+	// Template configuration.
+	templateConfig = template.Config{
+		ConfDir:       config.ConfDir,
+		ConfigDir:     filepath.Join(config.ConfDir, "conf.d"),
+		KeepStageFile: keepStageFile,
+		Noop:          config.Noop,
+		Prefix:        config.Prefix,
+		SyncOnly:      config.SyncOnly,
+		TemplateDir:   filepath.Join(config.ConfDir, "templates"),
+	}
+	return nil
+}
+
+// newBackendsConfig fills in the backend nodes of c, checks that its backend
+// is usable and returns the matching backends configuration. The layers of
+// a composite backend are handled the same way, in order.
+func newBackendsConfig(c *Config) (backends.Config, error) {
+	if c.SRVDomain != "" && c.SRVRecord == "" {
+		switch {
+		case c.Backend == "etcdv3" && c.Scheme == "https":
+			// etcd v3 advertises its client URLs under the etcd-client services.
+			c.SRVRecord = fmt.Sprintf("_etcd-client-ssl._tcp.%s.", c.SRVDomain)
+		case c.Backend == "etcdv3":
+			c.SRVRecord = fmt.Sprintf("_etcd-client._tcp.%s.", c.SRVDomain)
+		default:
+			c.SRVRecord = fmt.Sprintf("_%s._tcp.%s.", c.Backend, c.SRVDomain)
+		}
 	}
 
 	// Update BackendNodes from SRV records.
-	if config.Backend != "env" && config.SRVRecord != "" {
-		log.Info("SRV record set to " + config.SRVRecord)
-		srvNodes, err := getBackendNodesFromSRV(config.SRVRecord, config.Scheme)
+	if c.Backend != "env" && c.Backend != "file" && c.Backend != "git" && c.Backend != "composite" && c.SRVRecord != "" {
+		log.Info("SRV record set to " + c.SRVRecord)
+		srvNodes, err := getBackendNodesFromSRV(c.SRVRecord, c.Scheme)
 		if err != nil {
-			return errors.New("Cannot get nodes from SRV records " + err.Error())
+			return backends.Config{}, errors.New("Cannot get nodes from SRV records " + err.Error())
 		}
-		config.BackendNodes = srvNodes
+		c.BackendNodes = srvNodes
 	}
-	if len(config.BackendNodes) == 0 {
-		switch config.Backend {
+	if len(c.BackendNodes) == 0 {
+		switch c.Backend {
 		case "consul":
-			config.BackendNodes = []string{"127.0.0.1:8500"}
+			c.BackendNodes = []string{"127.0.0.1:8500"}
 		case "etcd":
 			peerstr := os.Getenv("ETCDCTL_PEERS")
 			if len(peerstr) > 0 {
-				config.BackendNodes = strings.Split(peerstr, ",")
+				c.BackendNodes = strings.Split(peerstr, ",")
 			} else {
-				config.BackendNodes = []string{"http://127.0.0.1:4001"}
+				c.BackendNodes = []string{"http://127.0.0.1:4001"}
+			}
+		case "etcdv3":
+			endpoints := os.Getenv("ETCDCTL_ENDPOINTS")
+			if len(endpoints) > 0 {
+				c.BackendNodes = strings.Split(endpoints, ",")
+			} else {
+				c.BackendNodes = []string{"http://127.0.0.1:2379"}
 			}
 		case "redis":
-			config.BackendNodes = []string{"127.0.0.1:6379"}
+			c.BackendNodes = []string{"127.0.0.1:6379"}
 		case "vault":
-			config.BackendNodes = []string{"http://127.0.0.1:8200"}
+			c.BackendNodes = []string{"http://127.0.0.1:8200"}
 		case "zookeeper":
-			config.BackendNodes = []string{"127.0.0.1:2181"}
+			c.BackendNodes = []string{"127.0.0.1:2181"}
 		}
 	}
 	// Initialize the storage client
-	log.Info("Backend set to " + config.Backend)
+	log.Info("Backend set to " + c.Backend)
 
-	if config.Watch {
-		unsupportedBackends := map[string]bool{
-			"redis":    true,
-			"dynamodb": true,
-			"rancher":  true,
-		}
+	if c.Backend == "dynamodb" && c.Table == "" {
+		return backends.Config{}, errors.New("No DynamoDB table configured")
+	}
 
-		if unsupportedBackends[config.Backend] {
-			log.Info(fmt.Sprintf("Watch is not supported for backend %s. Exiting...", config.Backend))
-			os.Exit(1)
-		}
+	if c.Backend == "sql" && len(c.BackendNodes) == 0 {
+		return backends.Config{}, errors.New("No SQL data source name configured, set it with -node")
 	}
 
-	if config.Backend == "dynamodb" && config.Table == "" {
-		return errors.New("No DynamoDB table configured")
+	if c.Backend == "git" && len(c.BackendNodes) == 0 {
+		return backends.Config{}, errors.New("No git repository configured, set it with -node")
 	}
 
-	backendsConfig = backends.Config{
-		AuthToken:    config.AuthToken,
-		AuthType:     config.AuthType,
-		Backend:      config.Backend,
-		BasicAuth:    config.BasicAuth,
-		ClientCaKeys: config.ClientCaKeys,
-		ClientCert:   config.ClientCert,
-		ClientKey:    config.ClientKey,
-		BackendNodes: config.BackendNodes,
-		Password:     config.Password,
-		Scheme:       config.Scheme,
-		Table:        config.Table,
-		Username:     config.Username,
-		AppID:        config.AppID,
-		UserID:       config.UserID,
+	if c.Backend == "composite" && len(c.Layers) == 0 {
+		return backends.Config{}, errors.New("No layers configured for the composite backend")
 	}
-	// Template configuration.
-	templateConfig = template.Config{
-		ConfDir:       config.ConfDir,
-		ConfigDir:     filepath.Join(config.ConfDir, "conf.d"),
-		KeepStageFile: keepStageFile,
-		Noop:          config.Noop,
-		Prefix:        config.Prefix,
-		SyncOnly:      config.SyncOnly,
-		TemplateDir:   filepath.Join(config.ConfDir, "templates"),
+
+	if c.Backend == "file" && len(c.File) == 0 && c.BackendOptions["file"] == nil {
+		return backends.Config{}, errors.New("No file configured")
+	}
+	if c.Backend == "file" && len(c.File) > 0 {
+		// The file setting predates the backend options, it is passed on as
+		// the file option of the file backend.
+		if c.BackendOptions == nil {
+			c.BackendOptions = make(map[string]interface{})
+		}
+		c.BackendOptions["file"] = c.File
+	}
+
+	bc := backends.Config{
+		AuthToken:    c.AuthToken,
+		AuthType:     c.AuthType,
+		Backend:      c.Backend,
+		BasicAuth:    c.BasicAuth,
+		ClientCaKeys: c.ClientCaKeys,
+		ClientCert:   c.ClientCert,
+		ClientKey:    c.ClientKey,
+		BackendNodes: c.BackendNodes,
+		Options:      c.BackendOptions,
+		Password:     c.Password,
+		Scheme:       c.Scheme,
+		Table:        c.Table,
+		Username:     c.Username,
+		AppID:        c.AppID,
+		UserID:       c.UserID,
+	}
+	for i := range c.Layers {
+		if c.Layers[i].Scheme == "" {
+			c.Layers[i].Scheme = c.Scheme
+		}
+		layer, err := newBackendsConfig(&c.Layers[i])
+		if err != nil {
+			return backends.Config{}, fmt.Errorf("layer %d: %s", i, err)
+		}
+		bc.Layers = append(bc.Layers, layer)
+	}
+	return bc, nil
+}
+
+// setBackendOptions adds options given as key=value to the backend options
+// of c, replacing those of the configuration file. Dots in keys separate
+// nested tables, so that settings.region=eu-west-1 sets the region key of
+// the settings table.
+func setBackendOptions(c *Config, options []string) error {
+	for _, option := range options {
+		i := strings.Index(option, "=")
+		if i <= 0 {
+			return fmt.Errorf("Invalid backend option %q, expected key=value", option)
+		}
+		if c.BackendOptions == nil {
+			c.BackendOptions = make(map[string]interface{})
+		}
+		table := c.BackendOptions
+		path := strings.Split(option[:i], ".")
+		for _, key := range path[:len(path)-1] {
+			next, ok := table[key].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				table[key] = next
+			}
+			table = next
+		}
+		table[path[len(path)-1]] = option[i+1:]
 	}
 	return nil
 }
@@ -290,6 +379,8 @@ func setConfigFromFlag(f *flag.Flag) {
 		config.ClientCaKeys = clientCaKeys
 	case "confdir":
 		config.ConfDir = confdir
+	case "file":
+		config.File = files
 	case "node":
 		config.BackendNodes = nodes
 	case "interval":
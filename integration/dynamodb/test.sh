@@ -64,9 +64,9 @@ then
         exit 1
 fi
 
-# Run confd with --watch, expecting it to fail
+# Run confd with --watch, expect it to work
 confd --onetime --log-level debug --confdir ./integration/confdir --interval 5 --backend dynamodb --table confd --watch
-if [ $? -eq 0 ]
+if [ $? -ne 0 ]
 then
         exit 1
 fi
@@ -0,0 +1,33 @@
+#!/bin/bash
+
+mkdir -p /tmp/confd-file-backend
+cat <<EOT > /tmp/confd-file-backend/config.yaml
+key: foobar
+database:
+  host: 127.0.0.1
+  password: p@sSw0rd
+  port: 3306
+  username: confd
+upstream:
+  app1: 10.0.1.10:8080
+  app2: 10.0.1.11:8080
+EOT
+
+cat <<EOT > /tmp/confd-file-backend/prefix.json
+{
+  "prefix": {
+    "database": {
+      "host": "127.0.0.1",
+      "password": "p@sSw0rd",
+      "port": 3306,
+      "username": "confd"
+    },
+    "upstream": {
+      "app1": "10.0.1.10:8080",
+      "app2": "10.0.1.11:8080"
+    }
+  }
+}
+EOT
+
+confd --onetime --log-level debug --confdir ./integration/confdir --backend file --file /tmp/confd-file-backend/config.yaml --file /tmp/confd-file-backend/prefix.json
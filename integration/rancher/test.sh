@@ -32,7 +32,7 @@ EOF
 rancher-metadata -listen 127.0.0.1:8080 --answers ./rancher-answers.json &
 
 confd --onetime --log-level debug --prefix /2015-07-25 --confdir ./integration/confdir --backend rancher --node 127.0.0.1:8080 --watch
-if [ $? -eq 0 ]
+if [ $? -ne 0 ]
 then
    exit 1
 fi
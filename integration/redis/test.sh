@@ -14,10 +14,8 @@ redis-cli set /prefix/database/username confd
 redis-cli set /prefix/upstream/app1 10.0.1.10:8080
 redis-cli set /prefix/upstream/app2 10.0.1.11:8080
 
-# Run confd with --watch, expecting it to fail
+# Watches rely on keyspace notifications
+redis-cli config set notify-keyspace-events KA
+
 confd --onetime --log-level debug --confdir ./integration/confdir --interval 5 --backend redis --node 127.0.0.1:6379 --watch
-if [ $? -eq 0 ]
-then
-        exit 1
-fi
 confd --onetime --log-level debug --confdir ./integration/confdir --interval 5 --backend redis --node 127.0.0.1:6379
@@ -0,0 +1,201 @@
+package template
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/consul"
+)
+
+// catalogClient is implemented by backends that know about services, such
+// as consul. Every method blocks until its result differs from the one at
+// waitIndex, unless waitIndex is 0 or ctx is done, and returns the index of
+// the result.
+type catalogClient interface {
+	Service(ctx context.Context, name, tag string, waitIndex uint64) ([]consul.ServiceInstance, uint64, error)
+	Services(ctx context.Context, waitIndex uint64) ([]consul.ServiceSummary, uint64, error)
+	Nodes(ctx context.Context, waitIndex uint64) ([]consul.Node, uint64, error)
+}
+
+var errNoCatalog = errors.New("the backend does not provide a service catalog")
+
+// catalogQuery identifies a catalog lookup made by a template.
+type catalogQuery struct {
+	kind string
+	name string
+	tag  string
+}
+
+// catalog backs the service, services and nodes template functions of a
+// template resource. It remembers the lookups of the last rendering so
+// that they can be watched.
+type catalog struct {
+	client catalogClient
+
+	mu      sync.Mutex
+	queries map[catalogQuery]uint64
+}
+
+func newCatalog(client backends.StoreClient) *catalog {
+	c, _ := client.(catalogClient)
+	return &catalog{client: c, queries: make(map[catalogQuery]uint64)}
+}
+
+// reset forgets the lookups of the previous rendering.
+func (c *catalog) reset() {
+	c.mu.Lock()
+	c.queries = make(map[catalogQuery]uint64)
+	c.mu.Unlock()
+}
+
+// run performs q, blocking until its result changes if waitIndex is not 0,
+// or until ctx is done.
+func (c *catalog) run(ctx context.Context, q catalogQuery, waitIndex uint64) (interface{}, uint64, error) {
+	if c.client == nil {
+		return nil, 0, errNoCatalog
+	}
+	switch q.kind {
+	case "service":
+		return c.client.Service(ctx, q.name, q.tag, waitIndex)
+	case "services":
+		return c.client.Services(ctx, waitIndex)
+	default:
+		return c.client.Nodes(ctx, waitIndex)
+	}
+}
+
+// lookup performs q for a template and records the index of its result.
+func (c *catalog) lookup(q catalogQuery) (interface{}, error) {
+	result, index, err := c.run(context.Background(), q, 0)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.queries[q] = index
+	c.mu.Unlock()
+	return result, nil
+}
+
+// service returns the healthy instances of a service, optionally only those
+// with the given tag.
+func (c *catalog) service(name string, tag ...string) ([]consul.ServiceInstance, error) {
+	q := catalogQuery{kind: "service", name: name}
+	if len(tag) > 0 {
+		q.tag = tag[0]
+	}
+	result, err := c.lookup(q)
+	if err != nil {
+		return nil, err
+	}
+	return result.([]consul.ServiceInstance), nil
+}
+
+// services returns the services of the catalog.
+func (c *catalog) services() ([]consul.ServiceSummary, error) {
+	result, err := c.lookup(catalogQuery{kind: "services"})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]consul.ServiceSummary), nil
+}
+
+// nodes returns the nodes of the catalog.
+func (c *catalog) nodes() ([]consul.Node, error) {
+	result, err := c.lookup(catalogQuery{kind: "nodes"})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]consul.Node), nil
+}
+
+func (c *catalog) active() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queries) > 0
+}
+
+// watch blocks until the result of one of the recorded lookups changes, or
+// until stopChan is closed. The pending queries are cancelled on return.
+func (c *catalog) watch(stopChan chan bool) error {
+	c.mu.Lock()
+	queries := make(map[catalogQuery]uint64, len(c.queries))
+	for q, index := range c.queries {
+		queries[q] = index
+	}
+	c.mu.Unlock()
+
+	type response struct {
+		query catalogQuery
+		index uint64
+		err   error
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	respChan := make(chan response, len(queries))
+	watch := func(q catalogQuery, waitIndex uint64) {
+		_, index, err := c.run(ctx, q, waitIndex)
+		respChan <- response{q, index, err}
+	}
+	for q, index := range queries {
+		go watch(q, index)
+	}
+
+	for {
+		select {
+		case <-stopChan:
+			return nil
+		case r := <-respChan:
+			if r.err != nil {
+				return r.err
+			}
+			if r.index != queries[r.query] {
+				return nil
+			}
+			// The blocking query timed out without a change.
+			go watch(r.query, r.index)
+		}
+	}
+}
+
+// catalogWatcher extends the watches of a store client to the catalog
+// lookups of a template resource.
+type catalogWatcher struct {
+	backends.StoreClient
+	catalog *catalog
+}
+
+// WatchPrefix returns when either a key below prefix or the result of a
+// catalog lookup changes.
+func (w *catalogWatcher) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if waitIndex == 0 || !w.catalog.active() {
+		return w.StoreClient.WatchPrefix(prefix, keys, waitIndex, stopChan)
+	}
+
+	stop := make(chan bool)
+	defer close(stop)
+
+	type response struct {
+		waitIndex uint64
+		err       error
+	}
+	kvChan := make(chan response, 1)
+	catalogChan := make(chan error, 1)
+	go func() {
+		index, err := w.StoreClient.WatchPrefix(prefix, keys, waitIndex, stop)
+		kvChan <- response{index, err}
+	}()
+	go func() {
+		catalogChan <- w.catalog.watch(stop)
+	}()
+
+	select {
+	case <-stopChan:
+		return waitIndex, nil
+	case r := <-kvChan:
+		return r.waitIndex, r.err
+	case err := <-catalogChan:
+		return waitIndex, err
+	}
+}
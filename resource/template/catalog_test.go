@@ -0,0 +1,133 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/kelseyhightower/confd/backends/consul"
+)
+
+// fakeCatalogStore is a store without keys whose web service changes when
+// the test sends new instances. Blocking queries given up by the watch are
+// reported on cancelled.
+type fakeCatalogStore struct {
+	index     uint64
+	web       []consul.ServiceInstance
+	changes   chan []consul.ServiceInstance
+	cancelled chan bool
+}
+
+func (s *fakeCatalogStore) GetValues(keys []string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (s *fakeCatalogStore) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	<-stopChan
+	return waitIndex, nil
+}
+
+func (s *fakeCatalogStore) Service(ctx context.Context, name, tag string, waitIndex uint64) ([]consul.ServiceInstance, uint64, error) {
+	if waitIndex != 0 {
+		select {
+		case s.web = <-s.changes:
+			s.index++
+		case <-ctx.Done():
+			s.cancelled <- true
+			return nil, waitIndex, ctx.Err()
+		}
+	}
+	return s.web, s.index, nil
+}
+
+func (s *fakeCatalogStore) Services(ctx context.Context, waitIndex uint64) ([]consul.ServiceSummary, uint64, error) {
+	return []consul.ServiceSummary{{Name: "web"}}, 1, nil
+}
+
+func (s *fakeCatalogStore) Nodes(ctx context.Context, waitIndex uint64) ([]consul.Node, uint64, error) {
+	return []consul.Node{{Name: "a", Address: "10.0.0.1"}}, 1, nil
+}
+
+func TestCatalogFuncs(t *testing.T) {
+	store := &fakeCatalogStore{
+		index:     1,
+		web:       []consul.ServiceInstance{{Address: "10.0.0.1", Port: 80}},
+		changes:   make(chan []consul.ServiceInstance),
+		cancelled: make(chan bool, 1),
+	}
+	c := newCatalog(store)
+	w := &catalogWatcher{store, c}
+
+	src := `{{range service "web"}}{{.Address}}:{{.Port}} {{end}}{{range services}}{{.Name}} {{end}}{{range nodes}}{{.Name}}{{end}}`
+	tmpl, err := template.New("test").Funcs(newFuncMap(c)).Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "10.0.0.1:80 web a"; out.String() != want {
+		t.Errorf("template = %q, want %q", out.String(), want)
+	}
+
+	done := make(chan uint64)
+	go func() {
+		index, err := w.WatchPrefix("/", []string{"/app"}, 5, make(chan bool))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- index
+	}()
+	select {
+	case <-done:
+		t.Fatal("WatchPrefix returned before the service changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	store.changes <- []consul.ServiceInstance{{Address: "10.0.0.2", Port: 80}}
+	select {
+	case index := <-done:
+		if index != 5 {
+			t.Errorf("WatchPrefix() = %d, want the unchanged key index 5", index)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WatchPrefix did not return after the service changed")
+	}
+}
+
+func TestCatalogFuncsWithoutCatalog(t *testing.T) {
+	c := newCatalog(nil)
+	if _, err := c.service("web"); err != errNoCatalog {
+		t.Errorf("service() error = %v, want %v", err, errNoCatalog)
+	}
+}
+
+func TestCatalogWatchCancelsQueries(t *testing.T) {
+	store := &fakeCatalogStore{
+		index:     1,
+		changes:   make(chan []consul.ServiceInstance),
+		cancelled: make(chan bool, 1),
+	}
+	c := newCatalog(store)
+	if _, err := c.service("web"); err != nil {
+		t.Fatal(err)
+	}
+	w := &catalogWatcher{store, c}
+
+	stopChan := make(chan bool)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(stopChan)
+	}()
+	if _, err := w.WatchPrefix("/", []string{"/app"}, 5, stopChan); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-store.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the blocking service query outlived the watch")
+	}
+}
@@ -47,6 +47,7 @@ type TemplateResource struct {
 	Src           string
 	StageFile     *os.File
 	Uid           int
+	catalog       *catalog
 	funcMap       map[string]interface{}
 	lastIndex     uint64
 	keepStageFile bool
@@ -78,7 +79,11 @@ func NewTemplateResource(tplpath string, config Config) (*TemplateResource, erro
 	tr.keepStageFile = config.KeepStageFile
 	tr.noop = config.Noop
 	tr.storeClient = config.StoreClient
-	tr.funcMap = newFuncMap()
+	tr.catalog = newCatalog(config.StoreClient)
+	if tr.catalog.client != nil {
+		tr.storeClient = &catalogWatcher{config.StoreClient, tr.catalog}
+	}
+	tr.funcMap = newFuncMap(tr.catalog)
 	tr.store = memkv.New()
 	tr.syncOnly = config.SyncOnly
 	addFuncs(tr.funcMap, tr.store.FuncMap)
@@ -146,6 +151,9 @@ func (t *TemplateResource) createStageFile() error {
 		return err
 	}
 
+	if t.catalog != nil {
+		t.catalog.reset()
+	}
 	if err = tmpl.Execute(temp, nil); err != nil {
 		temp.Close()
 		os.Remove(temp.Name())
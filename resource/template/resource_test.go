@@ -82,7 +82,7 @@ func TestProcessTemplateResources(t *testing.T) {
 	}
 
 	os.Setenv("FOO", "bar")
-	storeClient, err := env.NewEnvClient()
+	storeClient, err := env.NewEnvClient(env.Options{})
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -12,7 +12,7 @@ import (
 	"time"
 )
 
-func newFuncMap() map[string]interface{} {
+func newFuncMap(catalog *catalog) map[string]interface{} {
 	m := make(map[string]interface{})
 	m["base"] = path.Base
 	m["split"] = strings.Split
@@ -49,6 +49,9 @@ func newFuncMap() map[string]interface{} {
 	m["toYaml"] = ToYaml
 	m["base64Encode"] = Base64Encode
 	m["base64Decode"] = Base64Decode
+	m["service"] = catalog.service
+	m["services"] = catalog.services
+	m["nodes"] = catalog.nodes
 	return m
 }
 
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/kelseyhightower/confd/backends"
+	_ "github.com/kelseyhightower/confd/backends/env"
 )
 
 const (